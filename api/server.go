@@ -1,10 +1,11 @@
 package api
 
 import (
-	"encoding/gob"
 	"encoding/hex"
+	"fmt"
 	"net/http"
 	"strconv"
+	"strings"
 
 	"github.com/3ssalunke/go-blockchain/core"
 	"github.com/3ssalunke/go-blockchain/types"
@@ -25,6 +26,11 @@ type Block struct {
 	Version       uint32
 	DataHash      string
 	PrevBlockHash string
+	StateRoot     string
+	ReceiptsRoot  string
+	LogsBloom     string
+	GasLimit      uint64
+	GasUsed       uint64
 	Height        uint32
 	Timestamp     int64
 	Validator     string
@@ -33,6 +39,100 @@ type Block struct {
 	TxsResponse
 }
 
+type MerkleProof struct {
+	BlockHash string
+	DataHash  string
+	TxHash    string
+	Index     uint32
+	TxCount   uint32
+	Path      []string
+}
+
+type Log struct {
+	Address string
+	Topics  []string
+	Data    string
+}
+
+type FilteredLog struct {
+	BlockHash string
+	Height    uint32
+	TxHash    string
+	Index     uint32
+
+	Log
+}
+
+type Receipt struct {
+	TxHash  string
+	Status  string
+	GasUsed uint64
+	Logs    []Log
+	Error   string
+}
+
+type Account struct {
+	Address string
+	Balance uint64
+	Nonce   uint64
+}
+
+type Contract struct {
+	Address string
+	Code    string
+}
+
+type StorageAccess struct {
+	Op      string
+	Address string
+	Key     string
+	Value   string
+}
+
+type TraceStep struct {
+	Depth   int
+	PC      int
+	Op      string
+	Gas     uint64
+	GasCost uint64
+	Stack   []string
+	Storage []StorageAccess
+	Error   string
+}
+
+type Trace struct {
+	TxHash  string
+	Status  string
+	GasUsed uint64
+	Error   string
+	Steps   []TraceStep
+}
+
+// CallRequest is the body of a dry run. Addresses and byte fields are hex.
+type CallRequest struct {
+	From     string
+	To       string
+	Code     string
+	Input    string
+	Value    uint64
+	GasLimit uint64
+}
+
+type StateDiff struct {
+	Key    string
+	Before string
+	After  string
+}
+
+type CallResult struct {
+	ReturnValue string
+	StackTop    string
+	GasUsed     uint64
+	Logs        []Log
+	Diff        []StateDiff
+	Error       string
+}
+
 type ServerConfig struct {
 	ListenAddr string
 }
@@ -55,15 +155,22 @@ func (s *Server) Start() error {
 	e := echo.New()
 
 	e.GET("/block/:hashorid", s.handleGetBlock)
+	e.GET("/block/:hashorid/proof/:txhash", s.handleGetTxProof)
 	e.GET("/tx/:hash", s.handleGetTx)
+	e.GET("/receipt/:txhash", s.handleGetReceipt)
+	e.GET("/trace/:txhash", s.handleGetTrace)
+	e.GET("/logs", s.handleGetLogs)
+	e.GET("/account/:address", s.handleGetAccount)
+	e.GET("/code/:address", s.handleGetCode)
 	e.POST("/tx", s.handlePostTx)
+	e.POST("/call", s.handleCall)
 
 	return e.Start(s.ListenAddr)
 }
 
 func (s *Server) handlePostTx(c echo.Context) error {
 	tx := &core.Transaction{}
-	if err := gob.NewDecoder(c.Request().Body).Decode(tx); err != nil {
+	if err := tx.Decode(core.NewBinaryTxDecoder(c.Request().Body)); err != nil {
 		return c.JSON(http.StatusBadRequest, APIError{Error: err.Error()})
 	}
 
@@ -72,27 +179,77 @@ func (s *Server) handlePostTx(c echo.Context) error {
 	return nil
 }
 
-func (s *Server) handleGetBlock(c echo.Context) error {
-	hashOrId := c.Param("hashorid")
+// handleCall executes code or a contract call against the state after the
+// block at the height parameter, the head by default, and returns what it
+// would do without submitting anything.
+func (s *Server) handleCall(c echo.Context) error {
+	req := CallRequest{}
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, APIError{Error: err.Error()})
+	}
 
-	height, err := strconv.Atoi(hashOrId)
+	msg, err := parseCallRequest(req)
 	if err != nil {
-		block, err := s.bc.GetBlockByHeight(uint32(height))
+		return c.JSON(http.StatusBadRequest, APIError{Error: err.Error()})
+	}
+
+	height := s.bc.Height()
+	if h := c.QueryParam("height"); h != "" {
+		n, err := strconv.ParseUint(h, 10, 32)
 		if err != nil {
-			return c.JSON(http.StatusBadRequest, APIError{Error: err.Error()})
+			return c.JSON(http.StatusBadRequest, APIError{Error: fmt.Sprintf("invalid height %s", h)})
 		}
-
-		return c.JSON(http.StatusOK, toJsonBlock(block))
+		height = uint32(n)
 	}
 
-	b, err := hex.DecodeString(hashOrId)
+	result, err := s.bc.DryRun(msg, height)
 	if err != nil {
 		return c.JSON(http.StatusBadRequest, APIError{Error: err.Error()})
 	}
 
-	h := types.HashFromBytes(b)
+	return c.JSON(http.StatusOK, toJsonCallResult(result))
+}
+
+func parseCallRequest(req CallRequest) (*core.CallMsg, error) {
+	msg := &core.CallMsg{
+		Value:    req.Value,
+		GasLimit: req.GasLimit,
+	}
+
+	var err error
+	if req.From != "" {
+		if msg.From, err = parseAddress(req.From); err != nil {
+			return nil, err
+		}
+	}
+	if req.To != "" {
+		if msg.To, err = parseAddress(req.To); err != nil {
+			return nil, err
+		}
+	}
+	if msg.Code, err = hex.DecodeString(req.Code); err != nil {
+		return nil, fmt.Errorf("invalid code: %s", err)
+	}
+	if msg.Input, err = hex.DecodeString(req.Input); err != nil {
+		return nil, fmt.Errorf("invalid input: %s", err)
+	}
+	if len(msg.Code) == 0 && req.To == "" {
+		return nil, fmt.Errorf("either code or a contract address to call is required")
+	}
+
+	return msg, nil
+}
 
-	block, err := s.bc.GetBlockByHash(h)
+func parseAddress(s string) (types.Address, error) {
+	b, err := hex.DecodeString(s)
+	if err != nil || len(b) != 20 {
+		return types.Address{}, fmt.Errorf("invalid address %s", s)
+	}
+	return types.AddressFromBytes(b), nil
+}
+
+func (s *Server) handleGetBlock(c echo.Context) error {
+	block, err := s.getBlock(c.Param("hashorid"))
 	if err != nil {
 		return c.JSON(http.StatusBadRequest, APIError{Error: err.Error()})
 	}
@@ -100,6 +257,57 @@ func (s *Server) handleGetBlock(c echo.Context) error {
 	return c.JSON(http.StatusOK, toJsonBlock(block))
 }
 
+func (s *Server) handleGetTxProof(c echo.Context) error {
+	block, err := s.getBlock(c.Param("hashorid"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, APIError{Error: err.Error()})
+	}
+
+	hash, err := hex.DecodeString(c.Param("txhash"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, APIError{Error: err.Error()})
+	}
+	if len(hash) != 32 {
+		return c.JSON(http.StatusBadRequest, APIError{Error: "invalid transaction hash"})
+	}
+
+	proof, err := block.MerkleProof(types.HashFromBytes(hash))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, APIError{Error: err.Error()})
+	}
+
+	path := make([]string, len(proof.Path))
+	for i, h := range proof.Path {
+		path[i] = h.String()
+	}
+
+	return c.JSON(http.StatusOK, MerkleProof{
+		BlockHash: block.Hash(core.BlockHasher{}).String(),
+		DataHash:  block.DataHash.String(),
+		TxHash:    proof.TxHash.String(),
+		Index:     proof.Index,
+		TxCount:   proof.TxCount,
+		Path:      path,
+	})
+}
+
+func (s *Server) getBlock(hashOrId string) (*core.Block, error) {
+	height, err := strconv.Atoi(hashOrId)
+	if err == nil {
+		return s.bc.GetBlockByHeight(uint32(height))
+	}
+
+	b, err := hex.DecodeString(hashOrId)
+	if err != nil {
+		return nil, err
+	}
+	if len(b) != 32 {
+		return nil, fmt.Errorf("invalid block hash %s", hashOrId)
+	}
+
+	return s.bc.GetBlockByHash(types.HashFromBytes(b))
+}
+
 func (s *Server) handleGetTx(c echo.Context) error {
 	hashParam := c.Param("hash")
 
@@ -115,6 +323,162 @@ func (s *Server) handleGetTx(c echo.Context) error {
 	return c.JSON(http.StatusOK, tx)
 }
 
+func (s *Server) handleGetReceipt(c echo.Context) error {
+	hash, err := hex.DecodeString(c.Param("txhash"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, APIError{Error: err.Error()})
+	}
+	if len(hash) != 32 {
+		return c.JSON(http.StatusBadRequest, APIError{Error: "invalid transaction hash"})
+	}
+
+	receipt, err := s.bc.GetReceipt(types.HashFromBytes(hash))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, APIError{Error: err.Error()})
+	}
+
+	return c.JSON(http.StatusOK, toJsonReceipt(receipt))
+}
+
+// handleGetTrace re-executes a transaction of the canonical chain and
+// returns every VM step it took.
+func (s *Server) handleGetTrace(c echo.Context) error {
+	hash, err := hex.DecodeString(c.Param("txhash"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, APIError{Error: err.Error()})
+	}
+	if len(hash) != 32 {
+		return c.JSON(http.StatusBadRequest, APIError{Error: "invalid transaction hash"})
+	}
+
+	tracer := core.NewTraceLogger()
+	receipt, err := s.bc.TraceTransaction(types.HashFromBytes(hash), tracer)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, APIError{Error: err.Error()})
+	}
+
+	steps := make([]TraceStep, len(tracer.Steps))
+	for i, step := range tracer.Steps {
+		steps[i] = toJsonTraceStep(step)
+	}
+
+	return c.JSON(http.StatusOK, Trace{
+		TxHash:  receipt.TxHash.String(),
+		Status:  receipt.Status.String(),
+		GasUsed: receipt.GasUsed,
+		Error:   receipt.Error,
+		Steps:   steps,
+	})
+}
+
+// handleGetLogs returns the logs in the block range [from, to] emitted by any
+// of the address parameters and carrying any of the topic parameters. The
+// range defaults to the whole chain.
+func (s *Server) handleGetLogs(c echo.Context) error {
+	filter, err := s.parseLogFilter(c)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, APIError{Error: err.Error()})
+	}
+
+	logs, err := s.bc.GetLogs(filter)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, APIError{Error: err.Error()})
+	}
+
+	resp := make([]FilteredLog, len(logs))
+	for i, l := range logs {
+		resp[i] = FilteredLog{
+			BlockHash: l.BlockHash.String(),
+			Height:    l.Height,
+			TxHash:    l.TxHash.String(),
+			Index:     l.Index,
+			Log:       toJsonLog(l.Log),
+		}
+	}
+
+	return c.JSON(http.StatusOK, resp)
+}
+
+func (s *Server) parseLogFilter(c echo.Context) (core.LogFilter, error) {
+	filter := core.LogFilter{ToHeight: s.bc.Height()}
+
+	if from := c.QueryParam("from"); from != "" {
+		height, err := strconv.ParseUint(from, 10, 32)
+		if err != nil {
+			return filter, fmt.Errorf("invalid from height %s", from)
+		}
+		filter.FromHeight = uint32(height)
+	}
+	if to := c.QueryParam("to"); to != "" {
+		height, err := strconv.ParseUint(to, 10, 32)
+		if err != nil {
+			return filter, fmt.Errorf("invalid to height %s", to)
+		}
+		filter.ToHeight = uint32(height)
+	}
+
+	params := c.QueryParams()
+	for _, address := range params["address"] {
+		b, err := hex.DecodeString(address)
+		if err != nil || len(b) != 20 {
+			return filter, fmt.Errorf("invalid address %s", address)
+		}
+		filter.Addresses = append(filter.Addresses, types.AddressFromBytes(b))
+	}
+	for _, topic := range params["topic"] {
+		b, err := hex.DecodeString(topic)
+		if err != nil || len(b) != 32 {
+			return filter, fmt.Errorf("invalid topic %s", topic)
+		}
+		filter.Topics = append(filter.Topics, types.HashFromBytes(b))
+	}
+
+	return filter, nil
+}
+
+func (s *Server) handleGetAccount(c echo.Context) error {
+	b, err := hex.DecodeString(c.Param("address"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, APIError{Error: err.Error()})
+	}
+	if len(b) != 20 {
+		return c.JSON(http.StatusBadRequest, APIError{Error: "invalid address"})
+	}
+
+	addr := types.AddressFromBytes(b)
+	acc, err := s.bc.GetAccount(addr)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, APIError{Error: err.Error()})
+	}
+
+	return c.JSON(http.StatusOK, Account{
+		Address: addr.String(),
+		Balance: acc.Balance,
+		Nonce:   acc.Nonce,
+	})
+}
+
+func (s *Server) handleGetCode(c echo.Context) error {
+	b, err := hex.DecodeString(c.Param("address"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, APIError{Error: err.Error()})
+	}
+	if len(b) != 20 {
+		return c.JSON(http.StatusBadRequest, APIError{Error: "invalid address"})
+	}
+
+	addr := types.AddressFromBytes(b)
+	code, err := s.bc.GetCode(addr)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, APIError{Error: err.Error()})
+	}
+
+	return c.JSON(http.StatusOK, Contract{
+		Address: addr.String(),
+		Code:    hex.EncodeToString(code),
+	})
+}
+
 func toJsonBlock(block *core.Block) Block {
 	txResponse := TxsResponse{
 		TxCount: uint(len(block.Transactions)),
@@ -131,9 +495,115 @@ func toJsonBlock(block *core.Block) Block {
 		Height:        block.Header.Height,
 		DataHash:      block.Header.DataHash.String(),
 		PrevBlockHash: block.Header.PrevBlockHash.String(),
+		StateRoot:     block.Header.StateRoot.String(),
+		ReceiptsRoot:  block.Header.ReceiptsRoot.String(),
+		LogsBloom:     hex.EncodeToString(block.Header.LogsBloom[:]),
+		GasLimit:      block.Header.GasLimit,
+		GasUsed:       block.Header.GasUsed,
 		Timestamp:     block.Header.Timestamp,
 		Validator:     block.Validator.Address().String(),
 		Signature:     block.Signature.String(),
 		TxsResponse:   txResponse,
 	}
 }
+
+func toJsonReceipt(receipt *core.Receipt) Receipt {
+	logs := make([]Log, len(receipt.Logs))
+	for i, l := range receipt.Logs {
+		logs[i] = toJsonLog(l)
+	}
+
+	return Receipt{
+		TxHash:  receipt.TxHash.String(),
+		Status:  receipt.Status.String(),
+		GasUsed: receipt.GasUsed,
+		Logs:    logs,
+		Error:   receipt.Error,
+	}
+}
+
+func toJsonLog(l *core.Log) Log {
+	topics := make([]string, len(l.Topics))
+	for i, topic := range l.Topics {
+		topics[i] = topic.String()
+	}
+
+	return Log{
+		Address: l.Address.String(),
+		Topics:  topics,
+		Data:    hex.EncodeToString(l.Data),
+	}
+}
+
+func toJsonTraceStep(step *core.TraceStep) TraceStep {
+	stack := make([]string, len(step.Stack))
+	for i, v := range step.Stack {
+		stack[i] = v.String()
+	}
+
+	storage := make([]StorageAccess, len(step.Storage))
+	for i, access := range step.Storage {
+		storage[i] = StorageAccess{
+			Op:      access.Op.String(),
+			Address: access.Address.String(),
+			Key:     hex.EncodeToString(access.Key),
+			Value:   hex.EncodeToString(access.Value),
+		}
+	}
+
+	jsonStep := TraceStep{
+		Depth:   step.Depth,
+		PC:      step.PC,
+		Op:      step.Op.String(),
+		Gas:     step.Gas,
+		GasCost: step.GasCost,
+		Stack:   stack,
+		Storage: storage,
+	}
+	if step.Err != nil {
+		jsonStep.Error = step.Err.Error()
+	}
+
+	return jsonStep
+}
+
+func toJsonCallResult(result *core.DryRunResult) CallResult {
+	logs := make([]Log, len(result.Logs))
+	for i, l := range result.Logs {
+		logs[i] = toJsonLog(l)
+	}
+
+	diff := make([]StateDiff, len(result.Diff))
+	for i, d := range result.Diff {
+		diff[i] = StateDiff{
+			Key:    formatStateKey(d.Key),
+			Before: hex.EncodeToString(d.Before),
+			After:  hex.EncodeToString(d.After),
+		}
+	}
+
+	resp := CallResult{
+		ReturnValue: hex.EncodeToString(result.ReturnValue),
+		GasUsed:     result.GasUsed,
+		Logs:        logs,
+		Diff:        diff,
+	}
+	if result.StackTop != nil {
+		resp.StackTop = result.StackTop.String()
+	}
+	if result.Err != nil {
+		resp.Error = result.Err.Error()
+	}
+
+	return resp
+}
+
+// formatStateKey renders a state key such as "account/<address>" with the
+// part after the prefix in hex.
+func formatStateKey(key []byte) string {
+	prefix, rest, ok := strings.Cut(string(key), "/")
+	if !ok {
+		return hex.EncodeToString(key)
+	}
+	return prefix + "/" + hex.EncodeToString([]byte(rest))
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/3ssalunke/go-blockchain/core"
+)
+
+const usage = `usage:
+  go-blockchain                 run a local node
+  go-blockchain asm <file>      assemble a VM program and print its bytecode in hex
+  go-blockchain disasm <file>   disassemble hex encoded bytecode
+
+A file of - reads from stdin.`
+
+// runCommand runs the tool subcommand named by args[0].
+func runCommand(args []string) error {
+	if len(args) != 2 || (args[0] != "asm" && args[0] != "disasm") {
+		return errors.New(usage)
+	}
+
+	input, err := readInput(args[1])
+	if err != nil {
+		return err
+	}
+
+	switch args[0] {
+	case "asm":
+		code, err := core.Assemble(string(input))
+		if err != nil {
+			return err
+		}
+		fmt.Println(hex.EncodeToString(code))
+	case "disasm":
+		code, err := hex.DecodeString(strings.TrimPrefix(strings.TrimSpace(string(input)), "0x"))
+		if err != nil {
+			return fmt.Errorf("invalid bytecode: %s", err)
+		}
+		fmt.Print(core.Disassemble(code))
+	}
+
+	return nil
+}
+
+func readInput(name string) ([]byte, error) {
+	if name == "-" {
+		return io.ReadAll(os.Stdin)
+	}
+	return os.ReadFile(name)
+}
@@ -0,0 +1,86 @@
+package core
+
+import (
+	"encoding/binary"
+	"fmt"
+
+	"github.com/3ssalunke/go-blockchain/types"
+)
+
+const accountKeyPrefix = "account/"
+
+type Account struct {
+	Balance uint64
+	Nonce   uint64
+}
+
+func (a *Account) Bytes() []byte {
+	buf := make([]byte, 16)
+	binary.LittleEndian.PutUint64(buf[0:8], a.Balance)
+	binary.LittleEndian.PutUint64(buf[8:16], a.Nonce)
+	return buf
+}
+
+func AccountFromBytes(b []byte) (*Account, error) {
+	if len(b) != 16 {
+		return nil, fmt.Errorf("given bytes with length %d should be 16", len(b))
+	}
+
+	return &Account{
+		Balance: binary.LittleEndian.Uint64(b[0:8]),
+		Nonce:   binary.LittleEndian.Uint64(b[8:16]),
+	}, nil
+}
+
+// GenesisAlloc assigns the initial balances of the chain.
+type GenesisAlloc map[types.Address]uint64
+
+// GetAccount returns the account stored at addr. Accounts that were never
+// written have a zero balance and nonce.
+func (s *State) GetAccount(addr types.Address) (*Account, error) {
+	value, ok := s.data[accountKey(addr)]
+	if !ok {
+		return &Account{}, nil
+	}
+	return AccountFromBytes(value)
+}
+
+func (s *State) PutAccount(addr types.Address, acc *Account) error {
+	return s.Put([]byte(accountKey(addr)), acc.Bytes())
+}
+
+func (s *State) AddBalance(addr types.Address, amount uint64) error {
+	acc, err := s.GetAccount(addr)
+	if err != nil {
+		return err
+	}
+
+	if acc.Balance+amount < acc.Balance {
+		return fmt.Errorf("balance of account %s overflows", addr)
+	}
+	acc.Balance += amount
+
+	return s.PutAccount(addr, acc)
+}
+
+func (s *State) Transfer(from, to types.Address, amount uint64) error {
+	sender, err := s.GetAccount(from)
+	if err != nil {
+		return err
+	}
+
+	if sender.Balance < amount {
+		return fmt.Errorf("account %s has insufficient balance %d to transfer %d", from, sender.Balance, amount)
+	}
+	sender.Balance -= amount
+
+	if err := s.PutAccount(from, sender); err != nil {
+		return err
+	}
+
+	return s.AddBalance(to, amount)
+}
+
+func accountKey(addr types.Address) string {
+	return accountKeyPrefix + string(addr.ToSlice())
+}
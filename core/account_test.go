@@ -0,0 +1,42 @@
+package core
+
+import (
+	"testing"
+
+	"github.com/3ssalunke/go-blockchain/crypto"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAccountEncoding(t *testing.T) {
+	acc := &Account{Balance: 1000, Nonce: 7}
+
+	decoded, err := AccountFromBytes(acc.Bytes())
+	assert.Nil(t, err)
+	assert.Equal(t, acc, decoded)
+
+	_, err = AccountFromBytes([]byte{0x01})
+	assert.NotNil(t, err)
+}
+
+func TestTransfer(t *testing.T) {
+	s := NewState()
+	from := crypto.GeneratePrivateKey().PublicKey().Address()
+	to := crypto.GeneratePrivateKey().PublicKey().Address()
+
+	acc, err := s.GetAccount(from)
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(0), acc.Balance)
+
+	assert.Nil(t, s.AddBalance(from, 100))
+	assert.Nil(t, s.Transfer(from, to, 40))
+
+	acc, err = s.GetAccount(from)
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(60), acc.Balance)
+	acc, err = s.GetAccount(to)
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(40), acc.Balance)
+
+	assert.NotNil(t, s.Transfer(from, to, 61))
+	assert.NotNil(t, s.AddBalance(to, ^uint64(0)))
+}
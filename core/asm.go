@@ -0,0 +1,307 @@
+package core
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+var instrNames = map[Instruction]string{
+	InstrPushInt:    "PUSHINT",
+	InstrPushByte:   "PUSHBYTE",
+	InstrPushInt32:  "PUSHINT32",
+	InstrPushInt64:  "PUSHINT64",
+	InstrPushBytes:  "PUSHBYTES",
+	InstrPack:       "PACK",
+	InstrPop:        "POP",
+	InstrDup:        "DUP",
+	InstrSwap:       "SWAP",
+	InstrConcat:     "CONCAT",
+	InstrSlice:      "SLICE",
+	InstrLen:        "LEN",
+	InstrStore:      "STORE",
+	InstrGet:        "GET",
+	InstrDelete:     "DELETE",
+	InstrCallData:   "CALLDATA",
+	InstrCaller:     "CALLER",
+	InstrAddress:    "ADDRESS",
+	InstrAdd:        "ADD",
+	InstrSub:        "SUB",
+	InstrMul:        "MUL",
+	InstrDiv:        "DIV",
+	InstrEq:         "EQ",
+	InstrLt:         "LT",
+	InstrGt:         "GT",
+	InstrAnd:        "AND",
+	InstrOr:         "OR",
+	InstrNot:        "NOT",
+	InstrJump:       "JUMP",
+	InstrJumpI:      "JUMPI",
+	InstrJumpDest:   "JUMPDEST",
+	InstrLog:        "LOG",
+	InstrCall:       "CALL",
+	InstrStaticCall: "STATICCALL",
+	InstrReturn:     "RETURN",
+	InstrRevert:     "REVERT",
+}
+
+var instrsByName = func() map[string]Instruction {
+	m := make(map[string]Instruction, len(instrNames))
+	for instr, name := range instrNames {
+		m[name] = instr
+	}
+	return m
+}()
+
+// String returns the assembly mnemonic of the instruction.
+func (instr Instruction) String() string {
+	if name, ok := instrNames[instr]; ok {
+		return name
+	}
+	return fmt.Sprintf("INVALID(0x%02x)", byte(instr))
+}
+
+// Assemble translates assembly source into bytecode. The source has one
+// instruction per line, written as its mnemonic, e.g. ADD, and comments start
+// with ; or #. Push instructions take their immediate as an operand:
+//
+//	PUSHINT 7          an integer of one byte
+//	PUSHINT32 70000    an integer of four bytes
+//	PUSHINT64 -1       an integer of eight bytes
+//	PUSHBYTE 0x41      a single byte
+//	PUSHBYTES 0x0102   bytes, given in hex or as a Go quoted string
+//
+// Besides the instructions there are two directives:
+//
+//	PUSH 42            push an integer with the smallest push that fits
+//	PUSH "text"        the same as PUSHBYTES
+//	PUSH @loop         push the offset of the label loop
+//	BYTE 0xff          emit a raw byte
+//
+// A label is defined by a name followed by a colon, e.g. "loop: JUMPDEST",
+// and must mark a JUMPDEST so that it can be jumped to.
+func Assemble(src string) ([]byte, error) {
+	stmts := []asmStmt{}
+	labels := map[string]int{}
+	pending := []string{}
+
+	offset := 0
+	for i, line := range strings.Split(src, "\n") {
+		line = strings.TrimSpace(stripComment(line))
+
+		for {
+			name, rest, ok := strings.Cut(line, ":")
+			if !ok || !isLabel(name) {
+				break
+			}
+			if _, exists := labels[name]; exists {
+				return nil, fmt.Errorf("line %d: label %s defined twice", i+1, name)
+			}
+			labels[name] = offset
+			pending = append(pending, name)
+			line = strings.TrimSpace(rest)
+		}
+		if line == "" {
+			continue
+		}
+
+		stmt, err := parseStmt(line)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %s", i+1, err)
+		}
+		stmt.line = i + 1
+		if len(pending) > 0 && (stmt.raw || stmt.instr != InstrJumpDest) {
+			return nil, fmt.Errorf("line %d: label %s does not mark a JUMPDEST", i+1, pending[0])
+		}
+		pending = pending[:0]
+
+		stmts = append(stmts, stmt)
+		offset += stmt.size()
+	}
+	if len(pending) > 0 {
+		return nil, fmt.Errorf("label %s does not mark a JUMPDEST", pending[0])
+	}
+
+	code := make([]byte, 0, offset)
+	for _, stmt := range stmts {
+		if stmt.label != "" {
+			dest, ok := labels[stmt.label]
+			if !ok {
+				return nil, fmt.Errorf("line %d: undefined label %s", stmt.line, stmt.label)
+			}
+			binary.LittleEndian.PutUint32(stmt.imm, uint32(dest))
+		}
+		if !stmt.raw {
+			code = append(code, byte(stmt.instr))
+		}
+		code = append(code, stmt.imm...)
+	}
+
+	return code, nil
+}
+
+// asmStmt is a parsed line of assembly.
+type asmStmt struct {
+	line  int
+	instr Instruction
+	imm   []byte
+	// raw is set for a BYTE directive, which only emits imm. label is set
+	// for a PUSH of a label offset, which is filled into imm once all
+	// labels are known.
+	raw   bool
+	label string
+}
+
+func (s asmStmt) size() int {
+	if s.raw {
+		return len(s.imm)
+	}
+	return 1 + len(s.imm)
+}
+
+func parseStmt(line string) (asmStmt, error) {
+	op, arg, _ := strings.Cut(line, " ")
+	op = strings.ToUpper(op)
+	arg = strings.TrimSpace(arg)
+
+	switch op {
+	case "BYTE":
+		n, err := strconv.ParseUint(arg, 0, 8)
+		if err != nil {
+			return asmStmt{}, fmt.Errorf("invalid byte %q", arg)
+		}
+		return asmStmt{raw: true, imm: []byte{byte(n)}}, nil
+	case "PUSH":
+		switch {
+		case strings.HasPrefix(arg, "@"):
+			return asmStmt{instr: InstrPushInt32, imm: make([]byte, 4), label: arg[1:]}, nil
+		case strings.HasPrefix(arg, "\""), strings.HasPrefix(arg, "0x"):
+			op = InstrPushBytes.String()
+		default:
+			n, err := strconv.ParseInt(arg, 10, 64)
+			if err != nil {
+				return asmStmt{}, fmt.Errorf("invalid integer %q", arg)
+			}
+			switch {
+			case n >= 0 && n <= math.MaxUint8:
+				op = InstrPushInt.String()
+			case n >= 0 && n <= math.MaxUint32:
+				op = InstrPushInt32.String()
+			default:
+				op = InstrPushInt64.String()
+			}
+		}
+	}
+
+	instr, ok := instrsByName[op]
+	if !ok {
+		return asmStmt{}, fmt.Errorf("unknown instruction %s", op)
+	}
+	stmt := asmStmt{instr: instr}
+
+	var err error
+	switch instr {
+	case InstrPushInt, InstrPushByte:
+		var n uint64
+		n, err = strconv.ParseUint(arg, 0, 8)
+		stmt.imm = []byte{byte(n)}
+	case InstrPushInt32:
+		var n uint64
+		n, err = strconv.ParseUint(arg, 0, 32)
+		stmt.imm = binary.LittleEndian.AppendUint32(nil, uint32(n))
+	case InstrPushInt64:
+		var n int64
+		n, err = strconv.ParseInt(arg, 0, 64)
+		stmt.imm = binary.LittleEndian.AppendUint64(nil, uint64(n))
+	case InstrPushBytes:
+		var b []byte
+		b, err = parseBytes(arg)
+		stmt.imm = append(binary.LittleEndian.AppendUint32(nil, uint32(len(b))), b...)
+	default:
+		if arg != "" {
+			return stmt, fmt.Errorf("%s takes no operand", op)
+		}
+	}
+	if err != nil {
+		return stmt, fmt.Errorf("invalid operand %q for %s", arg, op)
+	}
+
+	return stmt, nil
+}
+
+// parseBytes parses a Go quoted string or hex prefixed with 0x.
+func parseBytes(s string) ([]byte, error) {
+	if strings.HasPrefix(s, "0x") {
+		return hex.DecodeString(s[2:])
+	}
+	str, err := strconv.Unquote(s)
+	return []byte(str), err
+}
+
+// Disassemble renders bytecode as assembly, one instruction per line with
+// its offset in a comment. Bytes that are not a valid instruction, such as
+// a truncated push, are rendered as BYTE, so the output always assembles
+// back to the same bytecode.
+func Disassemble(code []byte) string {
+	var b strings.Builder
+
+	for pc := 0; pc < len(code); {
+		instr := Instruction(code[pc])
+		n, ok := immediateSize(code, pc)
+
+		var text string
+		switch imm := code[pc+1 : pc+1+n]; {
+		case !instr.Valid() || !ok:
+			text = fmt.Sprintf("BYTE 0x%02x", code[pc])
+			n = 0
+		case instr == InstrPushInt || instr == InstrPushByte:
+			text = fmt.Sprintf("%s %d", instr, imm[0])
+		case instr == InstrPushInt32:
+			text = fmt.Sprintf("%s %d", instr, binary.LittleEndian.Uint32(imm))
+		case instr == InstrPushInt64:
+			text = fmt.Sprintf("%s %d", instr, int64(binary.LittleEndian.Uint64(imm)))
+		case instr == InstrPushBytes:
+			text = fmt.Sprintf("%s 0x%x", instr, imm[4:])
+		default:
+			text = instr.String()
+		}
+
+		fmt.Fprintf(&b, "%-24s ; %04d\n", text, pc)
+		pc += 1 + n
+	}
+
+	return b.String()
+}
+
+func isLabel(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i, c := range s {
+		letter := c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+		if !letter && (i == 0 || c < '0' || c > '9') {
+			return false
+		}
+	}
+	return true
+}
+
+// stripComment removes a comment starting with ; or # from line, ignoring
+// those characters inside string literals.
+func stripComment(line string) string {
+	quoted := false
+	for i := 0; i < len(line); i++ {
+		switch c := line[i]; {
+		case quoted && c == '\\':
+			i++
+		case c == '"':
+			quoted = !quoted
+		case !quoted && (c == ';' || c == '#'):
+			return line[:i]
+		}
+	}
+	return line
+}
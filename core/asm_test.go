@@ -0,0 +1,90 @@
+package core
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAssemble(t *testing.T) {
+	src := `
+		; stores "ab" under 7 unless the input is empty
+		CALLDATA
+		LEN
+		NOT
+		PUSH @end
+		JUMPI
+		PUSH "ab"    # a string literal
+		PUSH 0x07
+		STORE
+	end: JUMPDEST
+	`
+	code, err := Assemble(src)
+	assert.Nil(t, err)
+
+	want := program(InstrCallData, InstrLen, InstrNot, InstrPushInt32, []byte{23, 0, 0, 0}, InstrJumpI,
+		pushBytes([]byte("ab")), pushBytes([]byte{7}), InstrStore, InstrJumpDest)
+	assert.Equal(t, want, code)
+
+	state := NewState()
+	vm := NewVM(code, state, 10_000)
+	vm.input = []byte("x")
+	assert.Nil(t, vm.Run())
+	value, err := state.GetStorage(vm.address, []byte{7})
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("ab"), value)
+}
+
+func TestAssembleErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+	}{
+		{"unknown instruction", "FOO"},
+		{"unexpected operand", "ADD 1"},
+		{"operand too large", "PUSHINT 256"},
+		{"operand too small", "PUSHINT32 -1"},
+		{"invalid hex", "PUSH 0xzz"},
+		{"undefined label", "PUSH @nowhere"},
+		{"label not on jumpdest", "start: ADD"},
+		{"duplicate label", "a: JUMPDEST\na: JUMPDEST"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Assemble(tt.src)
+			assert.NotNil(t, err)
+		})
+	}
+}
+
+func TestDisassemble(t *testing.T) {
+	code := program(pushInt(5), pushBytes([]byte("hi")), InstrPushInt64, bytes.Repeat([]byte{0xff}, 8), InstrAdd, InstrJumpDest)
+	code = append(code, 0xff)
+
+	text := Disassemble(code)
+	assert.Contains(t, text, "PUSHINT 5")
+	assert.Contains(t, text, "PUSHBYTES 0x6869")
+	assert.Contains(t, text, "PUSHINT64 -1")
+	assert.Contains(t, text, "BYTE 0xff")
+
+	reassembled, err := Assemble(text)
+	assert.Nil(t, err)
+	assert.Equal(t, code, reassembled)
+
+	// A truncated push survives the round trip.
+	code = program(InstrAdd, InstrPushInt32, []byte{1, 2})
+	reassembled, err = Assemble(Disassemble(code))
+	assert.Nil(t, err)
+	assert.Equal(t, code, reassembled)
+}
+
+func TestAssemblePushWidths(t *testing.T) {
+	code, err := Assemble("PUSH 200\nPUSH 70000\nPUSH -2\nPUSH 0x\nPUSHBYTE 0x41")
+	assert.Nil(t, err)
+
+	want := program(pushInt(200), InstrPushInt32, []byte{0x70, 0x11, 0x01, 0x00},
+		InstrPushInt64, []byte{0xfe, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}, pushBytes(nil), InstrPushByte, []byte{0x41})
+	assert.Equal(t, want, code)
+}
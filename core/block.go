@@ -2,29 +2,44 @@ package core
 
 import (
 	"bytes"
-	"crypto/sha256"
-	"encoding/gob"
 	"fmt"
+	"sort"
 	"time"
 
 	"github.com/3ssalunke/go-blockchain/crypto"
 	"github.com/3ssalunke/go-blockchain/types"
+	"github.com/3ssalunke/go-blockchain/util"
 )
 
 type Header struct {
 	Version       uint32
 	DataHash      types.Hash
 	PrevBlockHash types.Hash
-	Timestamp     int64
-	Height        uint32
+	// StateRoot commits to the state after executing the block's
+	// transactions.
+	StateRoot types.Hash
+	// ReceiptsRoot is the Merkle root over the receipts of the block's
+	// transactions.
+	ReceiptsRoot types.Hash
+	// LogsBloom is the Bloom filter over the addresses and topics of the
+	// logs in the block's receipts.
+	LogsBloom Bloom
+	// GasLimit bounds the total gas the block's transactions may consume
+	// and GasUsed is what they actually consumed.
+	GasLimit  uint64
+	GasUsed   uint64
+	Timestamp int64
+	Height    uint32
 }
 
+// Bytes returns the canonical binary encoding of the header, which is what
+// the block hash and the validator's signature are computed over.
 func (h *Header) Bytes() []byte {
-	buf := &bytes.Buffer{}
-	enc := gob.NewEncoder(buf)
-	enc.Encode(h)
+	w := util.NewBinaryWriter()
+	w.WriteUint8(CodecVersion)
+	writeHeader(w, h)
 
-	return buf.Bytes()
+	return w.Bytes()
 }
 
 type Block struct {
@@ -115,27 +130,62 @@ func (b *Block) Hash(hasher Hasher[*Header]) types.Hash {
 	return b.hash
 }
 
-func CalculateDataHash(txx []*Transaction) (hash types.Hash, err error) {
-	buf := &bytes.Buffer{}
+// MerkleProof returns the proof that the transaction with the given hash is
+// committed to by the block's DataHash.
+func (b *Block) MerkleProof(txHash types.Hash) (*MerkleProof, error) {
+	return NewMerkleProof(b.Transactions, txHash)
+}
 
-	for _, tx := range txx {
-		if err = tx.Encode(NewGobTxEncoder(buf)); err != nil {
-			return
-		}
+// CalculateDataHash returns the Merkle root over the hashes of txx.
+func CalculateDataHash(txx []*Transaction) (hash types.Hash, err error) {
+	leaves := make([]types.Hash, len(txx))
+	for i, tx := range txx {
+		leaves[i] = tx.Hash(TxHasher{})
 	}
 
-	hash = sha256.Sum256(buf.Bytes())
+	hash = MerkleRoot(leaves)
 	return
 }
 
-func GenesisBlock() (*Block, error) {
+// GenesisBlock builds the first block of the chain. Every allocation is
+// recorded as an unsigned transfer transaction that credits its recipient
+// when the genesis block is applied.
+func GenesisBlock(alloc GenesisAlloc) (*Block, error) {
+	addrs := make([]types.Address, 0, len(alloc))
+	for addr := range alloc {
+		addrs = append(addrs, addr)
+	}
+	sort.Slice(addrs, func(i, j int) bool {
+		return bytes.Compare(addrs[i][:], addrs[j][:]) < 0
+	})
+
+	state := NewState()
+	txx := make([]*Transaction, len(addrs))
+	for i, addr := range addrs {
+		txx[i] = &Transaction{
+			Type:  TxTypeTransfer,
+			To:    addr,
+			Value: alloc[addr],
+		}
+		if err := state.AddBalance(addr, alloc[addr]); err != nil {
+			return nil, err
+		}
+	}
+
+	dataHash, err := CalculateDataHash(txx)
+	if err != nil {
+		return nil, err
+	}
+
 	header := &Header{
 		Version:   1,
+		DataHash:  dataHash,
+		StateRoot: state.Root(),
 		Timestamp: 00000000,
 		Height:    0,
 	}
 
-	b, err := NewBlock(header, nil)
+	b, err := NewBlock(header, txx)
 	if err != nil {
 		return nil, err
 	}
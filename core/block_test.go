@@ -10,10 +10,15 @@ import (
 )
 
 func randomBlock(height uint32, prevBlockHash types.Hash) (*Block, error) {
+	dataHash, err := CalculateDataHash([]*Transaction{})
+	if err != nil {
+		return nil, err
+	}
 	header := &Header{
 		Version:       1,
 		Height:        height,
 		PrevBlockHash: prevBlockHash,
+		DataHash:      dataHash,
 		Timestamp:     time.Now().UnixNano(),
 	}
 	return NewBlock(header, []*Transaction{})
@@ -25,6 +30,9 @@ func randomBlockWithSignature(t *testing.T, height uint32, prevBlockHash types.H
 	assert.Nil(t, err)
 	tx := randomTxWithSignature(t)
 	b.AddNewTransaction(tx)
+	dataHash, err := CalculateDataHash(b.Transactions)
+	assert.Nil(t, err)
+	b.DataHash = dataHash
 	assert.Nil(t, b.Sign(privKey))
 	return b
 }
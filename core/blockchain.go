@@ -1,12 +1,34 @@
 package core
 
 import (
+	"errors"
 	"fmt"
 	"sync"
 
 	"github.com/3ssalunke/go-blockchain/types"
 )
 
+// ReorgEvent is emitted when the canonical head switches to a block that
+// does not extend the previous head.
+type ReorgEvent struct {
+	OldHead        types.Hash
+	NewHead        types.Hash
+	CommonAncestor types.Hash
+	// Removed holds the blocks dropped from the canonical chain, highest
+	// first, and Added the blocks that replaced them, lowest first.
+	Removed []*Block
+	Added   []*Block
+}
+
+// DefaultBlockGasLimit is the block gas limit used when BlockchainOpts does
+// not set one.
+const DefaultBlockGasLimit uint64 = 10_000_000
+
+// MaxStateHistory is how many blocks below the head the state can be
+// recovered for, e.g. to trace a transaction or for a dry run. Side branch
+// blocks at that depth or below are not accepted and are pruned.
+const MaxStateHistory = 128
+
 type Blockchain struct {
 	store         Storage
 	lock          sync.RWMutex
@@ -16,35 +38,105 @@ type Blockchain struct {
 	txstore       map[types.Hash]*Transaction
 	validator     Validator
 	contractState *State
+	chainID       uint32
+	blockGasLimit uint64
+
+	// addLock serializes block insertion; lock only guards the indexes above.
+	addLock    sync.Mutex
+	forkChoice ForkChoice
+	nodes      map[types.Hash]*BlockNode
+	head       *BlockNode
+	undo       map[types.Hash]Journal
+	// prunedHeight is the height up to which side branches were pruned.
+	prunedHeight uint32
+	// receipts holds the receipts of the canonical blocks by block hash
+	// and txReceipts the same receipts by transaction hash.
+	receipts   map[types.Hash][]*Receipt
+	txReceipts map[types.Hash]*Receipt
+	// txBlocks holds the hash of the block of every canonical transaction.
+	txBlocks map[types.Hash]types.Hash
+
+	subsLock sync.Mutex
+	reorgSub []chan ReorgEvent
+}
+
+type BlockchainOpts struct {
+	// Store is where blocks are persisted. When it already holds blocks the
+	// chain is rebuilt from them; their genesis must match the one passed to
+	// NewBlockchain. Defaults to an in-memory store.
+	Store Storage
+	// ForkChoice decides which branch of the block tree is canonical.
+	// Defaults to LongestChain.
+	ForkChoice ForkChoice
+	// ChainID identifies the network; transactions carrying another chain
+	// ID are rejected.
+	ChainID uint32
+	// BlockGasLimit is the most gas a block may declare as its gas limit.
+	// Defaults to DefaultBlockGasLimit.
+	BlockGasLimit uint64
 }
 
-func NewBlockchain(genesis *Block) (*Blockchain, error) {
+func NewBlockchain(genesis *Block, opts *BlockchainOpts) (*Blockchain, error) {
+	if opts == nil {
+		opts = &BlockchainOpts{}
+	}
+	if opts.Store == nil {
+		opts.Store = NewMemStore()
+	}
+	if opts.ForkChoice == nil {
+		opts.ForkChoice = LongestChain{}
+	}
+	if opts.BlockGasLimit == 0 {
+		opts.BlockGasLimit = DefaultBlockGasLimit
+	}
+
 	bc := &Blockchain{
 		headers:       []*Header{},
-		store:         NewMemStore(),
+		store:         opts.Store,
 		contractState: NewState(),
 		blockstore:    make(map[types.Hash]*Block),
 		txstore:       make(map[types.Hash]*Transaction),
+		forkChoice:    opts.ForkChoice,
+		chainID:       opts.ChainID,
+		blockGasLimit: opts.BlockGasLimit,
+		nodes:         make(map[types.Hash]*BlockNode),
+		undo:          make(map[types.Hash]Journal),
+		receipts:      make(map[types.Hash][]*Receipt),
+		txReceipts:    make(map[types.Hash]*Receipt),
+		txBlocks:      make(map[types.Hash]types.Hash),
 	}
 	bc.validator = NewBlockValidator(bc)
-	err := bc.addBlockChainWithoutValidation(genesis)
+
+	loaded, err := bc.loadFromStore(genesis)
+	if err != nil {
+		return nil, err
+	}
+	if loaded {
+		return bc, nil
+	}
+
+	err = bc.addBlockChainWithoutValidation(genesis)
 
 	return bc, err
 }
 
+// AddBlock validates the block and inserts it into the block tree. A block
+// extending the canonical head is executed right away; a block on a side
+// branch is only kept until the fork choice prefers its branch, at which
+// point the chain is reorganized onto it.
 func (bc *Blockchain) AddBlock(b *Block) error {
-	if err := bc.validator.ValidateBlock(b); err != nil {
-		return err
-	}
+	return bc.addBlock(b, true)
+}
 
-	for _, tx := range b.Transactions {
-		vm := NewVM(tx.Data, bc.contractState)
-		if err := vm.Run(); err != nil {
-			return err
-		}
-	}
+// SubscribeReorgs returns a channel that receives an event for every
+// reorganization. Events are dropped for subscribers that fall behind.
+func (bc *Blockchain) SubscribeReorgs() <-chan ReorgEvent {
+	bc.subsLock.Lock()
+	defer bc.subsLock.Unlock()
 
-	return bc.addBlockChainWithoutValidation(b)
+	ch := make(chan ReorgEvent, 16)
+	bc.reorgSub = append(bc.reorgSub, ch)
+	return ch
 }
 
 func (bc *Blockchain) GetBlockByHash(hash types.Hash) (*Block, error) {
@@ -63,7 +155,7 @@ func (bc *Blockchain) GetBlockByHeight(height uint32) (*Block, error) {
 	bc.lock.RLock()
 	defer bc.lock.RUnlock()
 
-	if height > bc.Height() {
+	if height > bc.height() {
 		return nil, fmt.Errorf("given height (%d) is too high", height)
 	}
 
@@ -74,7 +166,7 @@ func (bc *Blockchain) GetHeader(height uint32) (*Header, error) {
 	bc.lock.RLock()
 	defer bc.lock.RUnlock()
 
-	if height > bc.Height() {
+	if height > bc.height() {
 		return nil, fmt.Errorf("given height (%d) is too high", height)
 	}
 
@@ -93,6 +185,42 @@ func (bc *Blockchain) GetTxByHash(hash types.Hash) (*Transaction, error) {
 	return tx, nil
 }
 
+// GetReceipts returns the receipts of the block with the given hash, in
+// transaction order. Receipts of blocks that are no longer canonical are
+// read from the store.
+func (bc *Blockchain) GetReceipts(blockHash types.Hash) ([]*Receipt, error) {
+	bc.lock.RLock()
+	receipts, ok := bc.receipts[blockHash]
+	bc.lock.RUnlock()
+	if ok {
+		return receipts, nil
+	}
+
+	return bc.store.GetReceipts(blockHash)
+}
+
+// GetReceipt returns the receipt of a transaction in the canonical chain.
+func (bc *Blockchain) GetReceipt(txHash types.Hash) (*Receipt, error) {
+	bc.lock.RLock()
+	defer bc.lock.RUnlock()
+
+	receipt, ok := bc.txReceipts[txHash]
+	if !ok {
+		return nil, fmt.Errorf("receipt not found for transaction %s", txHash)
+	}
+
+	return receipt, nil
+}
+
+func (bc *Blockchain) ChainID() uint32 {
+	return bc.chainID
+}
+
+// BlockGasLimit returns the most gas a block may consume.
+func (bc *Blockchain) BlockGasLimit() uint64 {
+	return bc.blockGasLimit
+}
+
 func (bc *Blockchain) HasBlock(height uint32) bool {
 	return height <= bc.Height()
 }
@@ -100,20 +228,668 @@ func (bc *Blockchain) HasBlock(height uint32) bool {
 func (bc *Blockchain) Height() uint32 {
 	bc.lock.RLock()
 	defer bc.lock.RUnlock()
+	return bc.height()
+}
+
+func (bc *Blockchain) height() uint32 {
 	return uint32(len(bc.headers) - 1)
 }
 
-func (bc *Blockchain) addBlockChainWithoutValidation(b *Block) error {
+func (bc *Blockchain) addBlock(b *Block, persist bool) error {
+	bc.addLock.Lock()
+	defer bc.addLock.Unlock()
+
+	if err := bc.validator.ValidateBlock(b); err != nil {
+		return err
+	}
+
+	parent := bc.nodes[b.PrevBlockHash]
+
+	if parent == bc.head {
+		changes, receipts, err := bc.executeBlock(b)
+		if err != nil {
+			return err
+		}
+		if persist {
+			if err := bc.store.Put(b); err != nil {
+				bc.contractState.Revert(changes)
+				return err
+			}
+			if err := bc.store.PutReceipts(b.Hash(BlockHasher{}), receipts); err != nil {
+				bc.contractState.Revert(changes)
+				return err
+			}
+		}
+
+		node := bc.insertNode(b, parent)
+		bc.undo[node.Hash] = changes
+		bc.appendCanonical(node, receipts)
+		bc.pruneSideBranches()
+
+		return bc.indexCanonical(node)
+	}
+
+	if bc.head.Height >= MaxStateHistory && b.Height <= bc.head.Height-MaxStateHistory {
+		return fmt.Errorf("block (%s) at height %d is too far below the head at height %d", b.Hash(BlockHasher{}), b.Height, bc.head.Height)
+	}
+
+	// The block is stored before the head can switch to it, so the chain in
+	// memory never gets ahead of the store. A block pruned after a failed
+	// reorg may be received again and is already stored.
+	if persist {
+		if _, err := bc.store.Get(b.Hash(BlockHasher{})); err != nil {
+			if err := bc.store.Put(b); err != nil {
+				return err
+			}
+		}
+	}
+
+	node := bc.insertNode(b, parent)
+
+	var added []*BlockNode
+	if bc.forkChoice.Better(node, bc.head) {
+		var err error
+		if added, err = bc.reorg(node); err != nil {
+			return err
+		}
+		bc.pruneSideBranches()
+		if err := bc.indexCanonical(added...); err != nil {
+			return err
+		}
+	}
+
+	if !persist {
+		return nil
+	}
+	for _, n := range added {
+		if err := bc.store.PutReceipts(n.Hash, bc.receiptsOf(n.Hash)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// indexCanonical records the nodes, lowest first, as the canonical blocks
+// at their heights in the store, replacing the ones above the first.
+func (bc *Blockchain) indexCanonical(nodes ...*BlockNode) error {
+	for _, node := range nodes {
+		if err := bc.store.SetCanonical(node.Height, node.Hash); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (bc *Blockchain) receiptsOf(blockHash types.Hash) []*Receipt {
+	bc.lock.RLock()
+	defer bc.lock.RUnlock()
+
+	return bc.receipts[blockHash]
+}
+
+// reorg switches the canonical chain to the branch ending at newHead and
+// returns the nodes that became canonical, lowest first. If a block on the
+// new branch fails to execute the previous canonical chain is restored and
+// the failing block and its descendants are pruned.
+func (bc *Blockchain) reorg(newHead *BlockNode) ([]*BlockNode, error) {
+	oldHead := bc.head
+
+	ancestor := oldHead.Ancestor(newHead.Height)
+	if ancestor == nil {
+		ancestor = oldHead
+	}
+	for newHead.Ancestor(ancestor.Height) != ancestor {
+		ancestor = ancestor.Parent
+	}
+
+	removed := []*BlockNode{}
+	for node := oldHead; node != ancestor; node = node.Parent {
+		removed = append(removed, node)
+	}
+	added := []*BlockNode{}
+	for node := newHead; node != ancestor; node = node.Parent {
+		added = append([]*BlockNode{node}, added...)
+	}
+
+	// Keep what is needed to put the removed blocks back without executing
+	// them again, should the new branch turn out to be invalid.
+	undo := make([]Journal, len(removed))
+	redo := make([]Journal, len(removed))
+	receipts := make([][]*Receipt, len(removed))
+	for i, node := range removed {
+		undo[i] = bc.undo[node.Hash]
+		redo[i] = bc.contractState.redo(undo[i])
+		receipts[i] = bc.receiptsOf(node.Hash)
+		bc.rollbackCanonical(node)
+	}
+
+	for i, node := range added {
+		changes, blockReceipts, err := bc.executeBlock(node.Block)
+		if err != nil {
+			for j := i - 1; j >= 0; j-- {
+				bc.rollbackCanonical(added[j])
+			}
+			for j := len(removed) - 1; j >= 0; j-- {
+				bc.contractState.Revert(redo[j])
+				bc.undo[removed[j].Hash] = undo[j]
+				bc.appendCanonical(removed[j], receipts[j])
+			}
+			bc.pruneNode(node)
+
+			return nil, fmt.Errorf("reorg to block (%s) failed: %s", newHead.Hash, err)
+		}
+
+		bc.undo[node.Hash] = changes
+		bc.appendCanonical(node, blockReceipts)
+	}
+
+	event := ReorgEvent{
+		OldHead:        oldHead.Hash,
+		NewHead:        newHead.Hash,
+		CommonAncestor: ancestor.Hash,
+	}
+	for _, node := range removed {
+		event.Removed = append(event.Removed, node.Block)
+	}
+	for _, node := range added {
+		event.Added = append(event.Added, node.Block)
+	}
+	bc.emitReorg(event)
+
+	return added, nil
+}
+
+func (bc *Blockchain) emitReorg(event ReorgEvent) {
+	bc.subsLock.Lock()
+	defer bc.subsLock.Unlock()
+
+	for _, ch := range bc.reorgSub {
+		select {
+		case ch <- event:
+		default:
+		}
+	}
+}
+
+// PrepareBlock fills in the header fields that depend on executing the
+// block, such as the state root and the gas used. Transactions that are
+// invalid or no longer fit in the block gas limit are dropped; the invalid
+// ones are returned, while the others may still fit in a later block. The
+// block must extend the current head and the chain state is left untouched.
+func (bc *Blockchain) PrepareBlock(b *Block) ([]*Transaction, error) {
+	bc.addLock.Lock()
+	defer bc.addLock.Unlock()
+
+	if b.PrevBlockHash != bc.head.Hash {
+		return nil, fmt.Errorf("block (%s) does not extend the current head", b.Hash(BlockHasher{}))
+	}
+
+	snapshot := bc.contractState.Snapshot()
+
+	var gasUsed uint64
+	txx := []*Transaction{}
+	invalid := []*Transaction{}
+	receipts := []*Receipt{}
+	for _, tx := range b.Transactions {
+		if bc.blockGasLimit-gasUsed < tx.GasLimit {
+			fmt.Printf("dropping transaction (%s) from block: exceeds block gas limit\n", tx.Hash(TxHasher{}))
+			continue
+		}
+		receipt, err := applyTransaction(bc.contractState, tx)
+		if err != nil {
+			fmt.Printf("dropping transaction (%s) from block: %s\n", tx.Hash(TxHasher{}), err)
+			invalid = append(invalid, tx)
+			continue
+		}
+		gasUsed += receipt.GasUsed
+		txx = append(txx, tx)
+		receipts = append(receipts, receipt)
+	}
+
+	b.Transactions = txx
+	b.GasLimit = bc.blockGasLimit
+	b.GasUsed = gasUsed
+	b.StateRoot = bc.contractState.Root()
+	b.ReceiptsRoot = CalculateReceiptsRoot(receipts)
+	b.LogsBloom = CreateBloom(receipts)
+	bc.contractState.RevertToSnapshot(snapshot)
+
+	dataHash, err := CalculateDataHash(txx)
+	if err != nil {
+		return nil, err
+	}
+	b.DataHash = dataHash
+
+	return invalid, nil
+}
+
+// GetAccount returns the account at addr in the state of the canonical head.
+func (bc *Blockchain) GetAccount(addr types.Address) (*Account, error) {
+	bc.addLock.Lock()
+	defer bc.addLock.Unlock()
+
+	return bc.contractState.GetAccount(addr)
+}
+
+// GetCode returns the code of the contract deployed at addr.
+func (bc *Blockchain) GetCode(addr types.Address) ([]byte, error) {
+	bc.addLock.Lock()
+	defer bc.addLock.Unlock()
+
+	return bc.contractState.GetCode(addr)
+}
+
+// TraceTransaction re-executes a transaction of the canonical chain against
+// the state it originally ran on, reporting every VM step to tracer, and
+// returns its receipt. If the tracer aborts the execution its error is
+// returned instead. The chain state is left untouched.
+func (bc *Blockchain) TraceTransaction(hash types.Hash, tracer Tracer) (*Receipt, error) {
+	var block *Block
+	state, err := bc.stateAt(func() (*BlockNode, error) {
+		bc.lock.RLock()
+		blockHash, ok := bc.txBlocks[hash]
+		bc.lock.RUnlock()
+		if !ok {
+			return nil, fmt.Errorf("transaction %s not found in the canonical chain", hash)
+		}
+		node := bc.nodes[blockHash]
+		if node.Parent == nil {
+			return nil, fmt.Errorf("transaction %s is a genesis allocation and was never executed", hash)
+		}
+		block = node.Block
+		return node.Parent, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// Replay the transactions that ran before it in its block.
+	for _, tx := range block.Transactions {
+		if tx.Hash(TxHasher{}) == hash {
+			return executeTransaction(state, tx, tracer)
+		}
+		if _, err := applyTransaction(state, tx); err != nil {
+			return nil, err
+		}
+	}
+
+	return nil, fmt.Errorf("transaction %s not found in block (%s)", hash, block.Hash(BlockHasher{}))
+}
+
+// stateAt returns a copy of the state right after the canonical block node
+// returns, obtained by undoing the blocks above it. node is called with
+// addLock held; the lock is released before the blocks are undone.
+func (bc *Blockchain) stateAt(node func() (*BlockNode, error)) (*State, error) {
+	state, undo, err := bc.snapshotAt(node)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, changes := range undo {
+		state.Revert(changes)
+	}
+
+	return state, nil
+}
+
+// snapshotAt copies the state of the head and collects the journals of the
+// blocks above the canonical block node returns, newest first.
+func (bc *Blockchain) snapshotAt(node func() (*BlockNode, error)) (*State, []Journal, error) {
+	bc.addLock.Lock()
+	defer bc.addLock.Unlock()
+
+	target, err := node()
+	if err != nil {
+		return nil, nil, err
+	}
+	depth := bc.head.Height - target.Height
+	if depth > MaxStateHistory {
+		return nil, nil, fmt.Errorf("state at height %d is %d blocks behind the head, at most %d are kept", target.Height, depth, MaxStateHistory)
+	}
+
+	undo := make([]Journal, 0, depth)
+	for n := bc.head; n != target; n = n.Parent {
+		undo = append(undo, bc.undo[n.Hash])
+	}
+
+	return bc.contractState.Copy(), undo, nil
+}
+
+// executeBlock runs the block's transactions against the contract state,
+// checks the resulting state root and returns the changes needed to undo
+// the block along with the transactions' receipts. On failure the state is
+// left as it was before the call.
+func (bc *Blockchain) executeBlock(b *Block) (Journal, []*Receipt, error) {
+	changes, receipts, err := bc.runBlock(b)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if root := bc.contractState.Root(); root != b.StateRoot {
+		bc.contractState.Revert(changes)
+		return nil, nil, fmt.Errorf("block (%s) has invalid state root, expected %s", b.Hash(BlockHasher{}), root)
+	}
+
+	if root := CalculateReceiptsRoot(receipts); root != b.ReceiptsRoot {
+		bc.contractState.Revert(changes)
+		return nil, nil, fmt.Errorf("block (%s) has invalid receipts root, expected %s", b.Hash(BlockHasher{}), root)
+	}
+
+	if CreateBloom(receipts) != b.LogsBloom {
+		bc.contractState.Revert(changes)
+		return nil, nil, fmt.Errorf("block (%s) has invalid logs bloom", b.Hash(BlockHasher{}))
+	}
+
+	return changes, receipts, nil
+}
+
+// runBlock executes the block's transactions. A transaction only runs if its
+// whole gas limit still fits in what is left of the block gas limit, so a
+// block can never take more than GasLimit gas to execute.
+func (bc *Blockchain) runBlock(b *Block) (Journal, []*Receipt, error) {
+	snapshot := bc.contractState.Snapshot()
+
+	fail := func(err error) (Journal, []*Receipt, error) {
+		bc.contractState.RevertToSnapshot(snapshot)
+		return nil, nil, err
+	}
+
+	var gasUsed uint64
+	receipts := make([]*Receipt, 0, len(b.Transactions))
+	for _, tx := range b.Transactions {
+		if b.GasLimit-gasUsed < tx.GasLimit {
+			return fail(fmt.Errorf("transaction (%s) exceeds the gas limit of block (%s)", tx.Hash(TxHasher{}), b.Hash(BlockHasher{})))
+		}
+		receipt, err := applyTransaction(bc.contractState, tx)
+		if err != nil {
+			return fail(err)
+		}
+		gasUsed += receipt.GasUsed
+		receipts = append(receipts, receipt)
+	}
+
+	if gasUsed != b.GasUsed {
+		return fail(fmt.Errorf("block (%s) has gas used %d, expected %d", b.Hash(BlockHasher{}), b.GasUsed, gasUsed))
+	}
+
+	return bc.contractState.Commit(), receipts, nil
+}
+
+// applyTransaction executes a single transaction against state and returns
+// its receipt. An error means the transaction is invalid and cannot be
+// included in a block; its changes are undone and any earlier changes are
+// kept. A transaction whose code faults is still valid: it gets a failed
+// receipt.
+func applyTransaction(state *State, tx *Transaction) (*Receipt, error) {
+	snapshot := state.Snapshot()
+
+	receipt, err := executeTransaction(state, tx, nil)
+	if err != nil {
+		state.RevertToSnapshot(snapshot)
+		return nil, err
+	}
+	return receipt, nil
+}
+
+// executeTransaction runs tx against state. If tracer is set it receives the
+// steps of the transaction's code.
+func executeTransaction(state *State, tx *Transaction, tracer Tracer) (*Receipt, error) {
+	from := tx.From.Address()
+
+	intrinsicGas := tx.IntrinsicGas()
+	if tx.GasLimit < intrinsicGas {
+		return nil, fmt.Errorf("transaction (%s) has gas limit %d, below its intrinsic gas %d", tx.Hash(TxHasher{}), tx.GasLimit, intrinsicGas)
+	}
+
+	sender, err := state.GetAccount(from)
+	if err != nil {
+		return nil, err
+	}
+	if tx.Nonce != sender.Nonce {
+		return nil, fmt.Errorf("transaction (%s) has nonce %d, expected %d", tx.Hash(TxHasher{}), tx.Nonce, sender.Nonce)
+	}
+	sender.Nonce++
+	if err := state.PutAccount(from, sender); err != nil {
+		return nil, err
+	}
+
+	switch tx.Type {
+	case TxTypeExec:
+		return runCode(state, tx, from, tx.Data, nil, tracer)
+	case TxTypeTransfer:
+		if err := state.Transfer(from, tx.To, tx.Value); err != nil {
+			return nil, err
+		}
+		return newReceipt(tx, intrinsicGas, nil, nil), nil
+	case TxTypeDeploy:
+		if len(tx.Data) == 0 {
+			return nil, fmt.Errorf("transaction (%s) deploys no code", tx.Hash(TxHasher{}))
+		}
+		if err := ValidateCode(tx.Data); err != nil {
+			return nil, fmt.Errorf("transaction (%s) deploys invalid code: %s", tx.Hash(TxHasher{}), err)
+		}
+		addr := ContractAddress(from, tx.Nonce)
+		if _, err := state.GetCode(addr); err == nil {
+			return nil, fmt.Errorf("contract already deployed at address %s", addr)
+		}
+		if err := state.PutCode(addr, tx.Data); err != nil {
+			return nil, err
+		}
+		if tx.Value > 0 {
+			if err := state.Transfer(from, addr, tx.Value); err != nil {
+				return nil, err
+			}
+		}
+		return newReceipt(tx, intrinsicGas, nil, nil), nil
+	case TxTypeCall:
+		code, err := state.GetCode(tx.To)
+		if err != nil {
+			return nil, err
+		}
+		return runCode(state, tx, tx.To, code, tx.Data, tracer)
+	default:
+		return nil, fmt.Errorf("unknown transaction type %d", tx.Type)
+	}
+}
+
+// runCode transfers the value of tx to addr and runs code with the storage
+// of addr. A fault does not invalidate the transaction: it stays in the
+// block with its nonce consumed, but none of its writes nor its transfer.
+// Except for a revert, a fault consumes all the gas.
+func runCode(state *State, tx *Transaction, addr types.Address, code, input []byte, tracer Tracer) (*Receipt, error) {
+	from := tx.From.Address()
+	snapshot := state.Snapshot()
+
+	if tx.Value > 0 {
+		if err := state.Transfer(from, addr, tx.Value); err != nil {
+			return nil, err
+		}
+	}
+
+	intrinsicGas := tx.IntrinsicGas()
+	vm := NewVM(code, state, tx.GasLimit-intrinsicGas)
+	vm.address = addr
+	vm.caller = from
+	vm.input = input
+	vm.tracer = tracer
+	err := vm.Run()
+	var abort *traceAbort
+	if errors.As(err, &abort) {
+		return nil, abort.err
+	}
+	gasUsed := intrinsicGas + vm.GasUsed()
+	logs := vm.Logs()
+	if err != nil {
+		state.RevertToSnapshot(snapshot)
+		logs = nil
+		if !errors.Is(err, ErrReverted) {
+			gasUsed = tx.GasLimit
+		}
+	}
+	return newReceipt(tx, gasUsed, logs, err), nil
+}
+
+// applyGenesis credits the allocations recorded in the genesis block.
+func (bc *Blockchain) applyGenesis(b *Block) error {
+	for _, tx := range b.Transactions {
+		if tx.Type != TxTypeTransfer {
+			continue
+		}
+		if err := bc.contractState.AddBalance(tx.To, tx.Value); err != nil {
+			return err
+		}
+	}
+	bc.contractState.Commit()
+
+	if root := bc.contractState.Root(); root != b.StateRoot {
+		return fmt.Errorf("genesis block has invalid state root, expected %s", root)
+	}
+
+	return nil
+}
+
+func (bc *Blockchain) insertNode(b *Block, parent *BlockNode) *BlockNode {
+	node := newBlockNode(b, parent)
+
 	bc.lock.Lock()
 	defer bc.lock.Unlock()
 
-	bc.headers = append(bc.headers, b.Header)
-	bc.blocks = append(bc.blocks, b)
-	bc.blockstore[b.Hash(BlockHasher{})] = b
+	bc.nodes[node.Hash] = node
+	bc.blockstore[node.Hash] = b
 
-	for _, tx := range b.Transactions {
-		bc.txstore[tx.Hash(TxHasher{})] = tx
+	return node
+}
+
+// pruneNode removes the node and all of its descendants from the block tree.
+func (bc *Blockchain) pruneNode(node *BlockNode) {
+	if parent := node.Parent; parent != nil {
+		for i, child := range parent.children {
+			if child == node {
+				parent.children = append(parent.children[:i], parent.children[i+1:]...)
+				break
+			}
+		}
+	}
+
+	bc.lock.Lock()
+	defer bc.lock.Unlock()
+
+	pending := []*BlockNode{node}
+	for len(pending) > 0 {
+		n := pending[0]
+		pending = append(pending[1:], n.children...)
+
+		delete(bc.nodes, n.Hash)
+		delete(bc.blockstore, n.Hash)
+	}
+}
+
+// pruneSideBranches removes the side branches forking off the canonical
+// chain at or below MaxStateHistory blocks under the head.
+func (bc *Blockchain) pruneSideBranches() {
+	if bc.head.Height < MaxStateHistory || bc.head.Height-MaxStateHistory <= bc.prunedHeight {
+		return
+	}
+	bound := bc.head.Height - MaxStateHistory
+
+	for n := bc.head.Ancestor(bound); n.Height > bc.prunedHeight; n = n.Parent {
+		siblings := append([]*BlockNode{}, n.Parent.children...)
+		for _, sibling := range siblings {
+			if sibling != n {
+				bc.pruneNode(sibling)
+			}
+		}
 	}
+	bc.prunedHeight = bound
+}
+
+func (bc *Blockchain) appendCanonical(node *BlockNode, receipts []*Receipt) {
+	bc.lock.Lock()
+	defer bc.lock.Unlock()
+
+	bc.head = node
+	bc.headers = append(bc.headers, node.Block.Header)
+	bc.blocks = append(bc.blocks, node.Block)
+
+	for _, tx := range node.Block.Transactions {
+		hash := tx.Hash(TxHasher{})
+		bc.txstore[hash] = tx
+		bc.txBlocks[hash] = node.Hash
+	}
+
+	bc.receipts[node.Hash] = receipts
+	for _, r := range receipts {
+		bc.txReceipts[r.TxHash] = r
+	}
+}
+
+// rollbackCanonical undoes the canonical head, which must be node.
+func (bc *Blockchain) rollbackCanonical(node *BlockNode) {
+	bc.contractState.Revert(bc.undo[node.Hash])
+	delete(bc.undo, node.Hash)
+
+	bc.lock.Lock()
+	defer bc.lock.Unlock()
+
+	bc.head = node.Parent
+	bc.headers = bc.headers[:len(bc.headers)-1]
+	bc.blocks = bc.blocks[:len(bc.blocks)-1]
+
+	for _, tx := range node.Block.Transactions {
+		delete(bc.txstore, tx.Hash(TxHasher{}))
+		delete(bc.txReceipts, tx.Hash(TxHasher{}))
+		delete(bc.txBlocks, tx.Hash(TxHasher{}))
+	}
+	delete(bc.receipts, node.Hash)
+}
+
+// loadFromStore rebuilds the block tree from the blocks already persisted in
+// the store, re-executing the canonical chain to restore the contract state.
+// The stored genesis must be genesis. It reports whether the store contained
+// any blocks.
+func (bc *Blockchain) loadFromStore(genesis *Block) (bool, error) {
+	loaded := false
+
+	err := bc.store.Iterate(func(b *Block) error {
+		if !loaded {
+			if b.Height != 0 {
+				return fmt.Errorf("first stored block has height %d, expected genesis", b.Height)
+			}
+			if hash, want := b.Hash(BlockHasher{}), genesis.Hash(BlockHasher{}); hash != want {
+				return fmt.Errorf("stored genesis block (%s) does not match genesis block (%s)", hash, want)
+			}
+			loaded = true
+			if err := bc.applyGenesis(b); err != nil {
+				return err
+			}
+			node := bc.insertNode(b, nil)
+			bc.appendCanonical(node, nil)
+			return bc.indexCanonical(node)
+		}
+
+		// Side branch blocks are stored before they are executed, so a
+		// block that could not be switched to when it was received fails
+		// again here and is left out.
+		if err := bc.addBlock(b, false); err != nil {
+			fmt.Printf("skipping stored block (%s): %s\n", b.Hash(BlockHasher{}), err)
+		}
+		return nil
+	})
+
+	return loaded, err
+}
+
+func (bc *Blockchain) addBlockChainWithoutValidation(b *Block) error {
+	if err := bc.applyGenesis(b); err != nil {
+		return err
+	}
+	if err := bc.store.Put(b); err != nil {
+		return err
+	}
+
+	node := bc.insertNode(b, nil)
+	bc.appendCanonical(node, nil)
 
-	return bc.store.Put(b)
+	return bc.indexCanonical(node)
 }
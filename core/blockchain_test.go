@@ -1,14 +1,17 @@
 package core
 
 import (
+	"fmt"
 	"testing"
 
+	"github.com/3ssalunke/go-blockchain/crypto"
+
 	"github.com/3ssalunke/go-blockchain/types"
 	"github.com/stretchr/testify/assert"
 )
 
 func newBlockchainWithGenesis(t *testing.T) *Blockchain {
-	bc, err := NewBlockchain(randomBlockWithSignature(t, 0, types.Hash{}))
+	bc, err := NewBlockchain(randomBlockWithSignature(t, 0, types.Hash{}), nil)
 	assert.Nil(t, err)
 	return bc
 }
@@ -22,8 +25,7 @@ func TestBlockchain(t *testing.T) {
 
 func TestAddBlock(t *testing.T) {
 	bc := newBlockchainWithGenesis(t)
-	prevBlockHash := getPrevBlockHash(t, bc, 1)
-	block := randomBlockWithSignature(t, 1, prevBlockHash)
+	block := preparedBlock(t, bc, randomTxWithSignature(t))
 
 	assert.Nil(t, bc.AddBlock(block))
 	assert.Equal(t, bc.Height(), uint32(1))
@@ -42,3 +44,579 @@ func getPrevBlockHash(t *testing.T, bc *Blockchain, height uint32) types.Hash {
 
 	return BlockHasher{}.Hash(prevHeader)
 }
+
+func TestAddBlockUnknownParent(t *testing.T) {
+	bc := newBlockchainWithGenesis(t)
+
+	assert.NotNil(t, bc.AddBlock(randomBlockWithSignature(t, 1, types.RandomHash())))
+	assert.Equal(t, uint32(0), bc.Height())
+}
+
+func TestReorg(t *testing.T) {
+	bc := newBlockchainWithGenesis(t)
+	events := bc.SubscribeReorgs()
+	genesisHash := getPrevBlockHash(t, bc, 1)
+
+	a1 := blockWithTxData(t, 1, genesisHash, NewState(), storeProgram('a', 1))
+	assert.Nil(t, bc.AddBlock(a1))
+
+	branchState := NewState()
+	b1 := blockWithTxData(t, 1, genesisHash, branchState, storeProgram('b', 2))
+	assert.Nil(t, bc.AddBlock(b1))
+
+	// Equal height, the first seen branch stays canonical.
+	head, err := bc.GetBlockByHeight(1)
+	assert.Nil(t, err)
+	assert.Equal(t, a1, head)
+	stored, err := bc.store.GetByHeight(1)
+	assert.Nil(t, err)
+	assert.Equal(t, a1, stored)
+	_, err = senderStorage(bc.contractState, a1.Transactions[0], "a")
+	assert.Nil(t, err)
+
+	b2 := blockWithTxData(t, 2, b1.Hash(BlockHasher{}), branchState, storeProgram('c', 3))
+	assert.Nil(t, bc.AddBlock(b2))
+	assert.Equal(t, b2.StateRoot, bc.contractState.Root())
+
+	assert.Equal(t, uint32(2), bc.Height())
+	head, err = bc.GetBlockByHeight(1)
+	assert.Nil(t, err)
+	assert.Equal(t, b1, head)
+	for height, b := range []*Block{b1, b2} {
+		stored, err := bc.store.GetByHeight(uint32(height + 1))
+		assert.Nil(t, err)
+		assert.Equal(t, b, stored)
+	}
+
+	_, err = senderStorage(bc.contractState, a1.Transactions[0], "a")
+	assert.NotNil(t, err)
+	_, err = senderStorage(bc.contractState, b1.Transactions[0], "b")
+	assert.Nil(t, err)
+	_, err = senderStorage(bc.contractState, b2.Transactions[0], "c")
+	assert.Nil(t, err)
+
+	_, err = bc.GetTxByHash(a1.Transactions[0].Hash(TxHasher{}))
+	assert.NotNil(t, err)
+	_, err = bc.GetTxByHash(b2.Transactions[0].Hash(TxHasher{}))
+	assert.Nil(t, err)
+
+	// The dropped block is still known as a side branch.
+	_, err = bc.GetBlockByHash(a1.Hash(BlockHasher{}))
+	assert.Nil(t, err)
+
+	ev := <-events
+	assert.Equal(t, a1.Hash(BlockHasher{}), ev.OldHead)
+	assert.Equal(t, b2.Hash(BlockHasher{}), ev.NewHead)
+	assert.Equal(t, genesisHash, ev.CommonAncestor)
+	assert.Equal(t, []*Block{a1}, ev.Removed)
+	assert.Equal(t, []*Block{b1, b2}, ev.Added)
+}
+
+func TestReorgReloadFromStore(t *testing.T) {
+	store := NewMemStore()
+	genesis := randomBlockWithSignature(t, 0, types.Hash{})
+	bc, err := NewBlockchain(genesis, &BlockchainOpts{Store: store})
+	assert.Nil(t, err)
+	genesisHash := getPrevBlockHash(t, bc, 1)
+
+	branchState := NewState()
+	a1 := blockWithTxData(t, 1, genesisHash, NewState(), storeProgram('a', 1))
+	b1 := blockWithTxData(t, 1, genesisHash, branchState, storeProgram('b', 2))
+	b2 := blockWithTxData(t, 2, b1.Hash(BlockHasher{}), branchState, storeProgram('c', 3))
+	for _, b := range []*Block{a1, b1, b2} {
+		assert.Nil(t, bc.AddBlock(b))
+	}
+
+	reloaded, err := NewBlockchain(genesis, &BlockchainOpts{Store: store})
+	assert.Nil(t, err)
+	assert.Equal(t, uint32(2), reloaded.Height())
+	head, err := reloaded.GetBlockByHeight(2)
+	assert.Nil(t, err)
+	assert.Equal(t, b2, head)
+	stored, err := store.GetByHeight(1)
+	assert.Nil(t, err)
+	assert.Equal(t, b1, stored)
+	_, err = senderStorage(reloaded.contractState, a1.Transactions[0], "a")
+	assert.NotNil(t, err)
+}
+
+func TestReorgInvalidBranch(t *testing.T) {
+	store := NewMemStore()
+	genesis := randomBlockWithSignature(t, 0, types.Hash{})
+	bc, err := NewBlockchain(genesis, &BlockchainOpts{Store: store})
+	assert.Nil(t, err)
+	genesisHash := getPrevBlockHash(t, bc, 1)
+
+	a1 := blockWithTxData(t, 1, genesisHash, NewState(), storeProgram('a', 1))
+	assert.Nil(t, bc.AddBlock(a1))
+
+	branchState := NewState()
+	b1 := blockWithTxData(t, 1, genesisHash, branchState, storeProgram('b', 2))
+	assert.Nil(t, bc.AddBlock(b1))
+	assert.Nil(t, branchState.Put([]byte("x"), []byte("y")))
+	b2 := blockWithTxData(t, 2, b1.Hash(BlockHasher{}), branchState, storeProgram('c', 3))
+
+	// b2 has a wrong state root, so the reorg onto it is undone.
+	assert.NotNil(t, bc.AddBlock(b2))
+	assert.Equal(t, uint32(1), bc.Height())
+	assert.Equal(t, a1.StateRoot, bc.contractState.Root())
+	_, err = senderStorage(bc.contractState, a1.Transactions[0], "a")
+	assert.Nil(t, err)
+	_, err = bc.GetReceipt(a1.Transactions[0].Hash(TxHasher{}))
+	assert.Nil(t, err)
+	_, err = bc.GetBlockByHash(b2.Hash(BlockHasher{}))
+	assert.NotNil(t, err)
+
+	// Receiving b2 again does not store it twice.
+	stored := len(store.blocks)
+	assert.NotNil(t, bc.AddBlock(b2))
+	assert.Equal(t, stored, len(store.blocks))
+
+	// The chain still extends a1.
+	a2 := blockWithTxData(t, 2, a1.Hash(BlockHasher{}), bc.contractState.Copy(), storeProgram('d', 4))
+	assert.Nil(t, bc.AddBlock(a2))
+	assert.Equal(t, uint32(2), bc.Height())
+
+	// The stored b2 is skipped when the chain is rebuilt.
+	reloaded, err := NewBlockchain(genesis, &BlockchainOpts{Store: store})
+	assert.Nil(t, err)
+	head, err := reloaded.GetBlockByHeight(2)
+	assert.Nil(t, err)
+	assert.Equal(t, a2, head)
+}
+
+func TestSideBranchDepth(t *testing.T) {
+	bc := newBlockchainWithGenesis(t)
+	genesisHash := getPrevBlockHash(t, bc, 1)
+
+	assert.Nil(t, bc.AddBlock(preparedBlock(t, bc)))
+	side := blockWithTxData(t, 1, genesisHash, NewState(), storeProgram('a', 1))
+	assert.Nil(t, bc.AddBlock(side))
+
+	for bc.Height() < MaxStateHistory+1 {
+		assert.Nil(t, bc.AddBlock(preparedBlock(t, bc)))
+	}
+
+	// The side branch is pruned once it is too deep, and can no longer
+	// be added.
+	_, err := bc.GetBlockByHash(side.Hash(BlockHasher{}))
+	assert.NotNil(t, err)
+	assert.NotNil(t, bc.AddBlock(side))
+
+	// A branch right above that depth is still kept.
+	parent, err := bc.GetBlockByHeight(1)
+	assert.Nil(t, err)
+	assert.Nil(t, bc.AddBlock(blockWithTxData(t, 2, parent.Hash(BlockHasher{}), NewState(), storeProgram('b', 2))))
+}
+
+// failingStore is a MemStore whose writes fail once fail is set.
+type failingStore struct {
+	*MemStore
+	fail bool
+}
+
+func (s *failingStore) Put(b *Block) error {
+	if s.fail {
+		return fmt.Errorf("disk full")
+	}
+	return s.MemStore.Put(b)
+}
+
+func TestReorgStoreFailure(t *testing.T) {
+	store := &failingStore{MemStore: NewMemStore()}
+	bc, err := NewBlockchain(randomBlockWithSignature(t, 0, types.Hash{}), &BlockchainOpts{Store: store})
+	assert.Nil(t, err)
+	genesisHash := getPrevBlockHash(t, bc, 1)
+
+	a1 := blockWithTxData(t, 1, genesisHash, NewState(), storeProgram('a', 1))
+	assert.Nil(t, bc.AddBlock(a1))
+
+	branchState := NewState()
+	b1 := blockWithTxData(t, 1, genesisHash, branchState, storeProgram('b', 2))
+	assert.Nil(t, bc.AddBlock(b1))
+	b2 := blockWithTxData(t, 2, b1.Hash(BlockHasher{}), branchState, storeProgram('c', 3))
+
+	// A block that cannot be stored is not switched to.
+	store.fail = true
+	assert.NotNil(t, bc.AddBlock(b2))
+	head, err := bc.GetBlockByHeight(bc.Height())
+	assert.Nil(t, err)
+	assert.Equal(t, a1, head)
+	assert.Equal(t, a1.StateRoot, bc.contractState.Root())
+
+	store.fail = false
+	assert.Nil(t, bc.AddBlock(b2))
+	assert.Equal(t, b2.StateRoot, bc.contractState.Root())
+}
+
+func TestAddBlockInvalidStateRoot(t *testing.T) {
+	bc := newBlockchainWithGenesis(t)
+	genesisHash := getPrevBlockHash(t, bc, 1)
+
+	state := NewState()
+	assert.Nil(t, state.Put([]byte("x"), []byte("y")))
+	b := blockWithTxData(t, 1, genesisHash, state, storeProgram('a', 1))
+
+	assert.NotNil(t, bc.AddBlock(b))
+	assert.Equal(t, uint32(0), bc.Height())
+	_, err := senderStorage(bc.contractState, b.Transactions[0], "a")
+	assert.NotNil(t, err)
+}
+
+func TestAddBlockInvalidReceiptsRoot(t *testing.T) {
+	bc := newBlockchainWithGenesis(t)
+
+	tx := NewTransaction(storeProgram('a', 1))
+	assert.Nil(t, tx.Sign(crypto.GeneratePrivateKey()))
+	b := preparedBlock(t, bc, tx)
+	assert.False(t, b.ReceiptsRoot.IsZero())
+
+	b.ReceiptsRoot = types.RandomHash()
+	assert.Nil(t, b.Sign(crypto.GeneratePrivateKey()))
+	assert.NotNil(t, bc.AddBlock(b))
+	assert.Equal(t, uint32(0), bc.Height())
+}
+
+func TestPrepareBlock(t *testing.T) {
+	bc := newBlockchainWithGenesis(t)
+	genesis, err := bc.GetHeader(0)
+	assert.Nil(t, err)
+
+	tx := NewTransaction(storeProgram('a', 1))
+	assert.Nil(t, tx.Sign(crypto.GeneratePrivateKey()))
+	b, err := NewBlockFromPrevHeader(genesis, []*Transaction{tx})
+	assert.Nil(t, err)
+
+	invalid, err := bc.PrepareBlock(b)
+	assert.Nil(t, err)
+	assert.Empty(t, invalid)
+	assert.False(t, b.StateRoot.IsZero())
+	assert.True(t, bc.contractState.Root().IsZero())
+
+	assert.Nil(t, b.Sign(crypto.GeneratePrivateKey()))
+	assert.Nil(t, bc.AddBlock(b))
+	assert.Equal(t, b.StateRoot, bc.contractState.Root())
+}
+
+// storeProgram returns bytecode that stores value under the single byte key.
+func storeProgram(key byte, value byte) []byte {
+	return program(pushInt(value), pushBytes([]byte{key}), InstrStore)
+}
+
+// senderStorage reads key from the storage of the sender of tx, which is
+// where the code of an exec transaction writes.
+func senderStorage(state *State, tx *Transaction, key string) ([]byte, error) {
+	return state.GetStorage(tx.From.Address(), []byte(key))
+}
+
+// blockWithTxData builds a signed block with a single transaction carrying
+// data. The transaction is executed against state, which must hold the state
+// of the parent block, to fill in the block's state root.
+func blockWithTxData(t *testing.T, height uint32, prevBlockHash types.Hash, state *State, data []byte) *Block {
+	privKey := crypto.GeneratePrivateKey()
+	b, err := randomBlock(height, prevBlockHash)
+	assert.Nil(t, err)
+
+	tx := NewTransaction(data)
+	assert.Nil(t, tx.Sign(privKey))
+	b.AddNewTransaction(tx)
+
+	dataHash, err := CalculateDataHash(b.Transactions)
+	assert.Nil(t, err)
+	b.DataHash = dataHash
+
+	receipt, err := applyTransaction(state, tx)
+	assert.Nil(t, err)
+	b.GasLimit = DefaultBlockGasLimit
+	b.GasUsed = receipt.GasUsed
+	b.StateRoot = state.Root()
+	b.ReceiptsRoot = CalculateReceiptsRoot([]*Receipt{receipt})
+	b.LogsBloom = CreateBloom([]*Receipt{receipt})
+
+	assert.Nil(t, b.Sign(privKey))
+
+	return b
+}
+
+func TestGenesisAlloc(t *testing.T) {
+	addr := crypto.GeneratePrivateKey().PublicKey().Address()
+	genesis, err := GenesisBlock(GenesisAlloc{addr: 500})
+	assert.Nil(t, err)
+
+	bc, err := NewBlockchain(genesis, nil)
+	assert.Nil(t, err)
+
+	acc, err := bc.GetAccount(addr)
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(500), acc.Balance)
+
+	genesis.StateRoot = types.RandomHash()
+	_, err = NewBlockchain(genesis, nil)
+	assert.NotNil(t, err)
+}
+
+func TestAddBlockTransfer(t *testing.T) {
+	privKey := crypto.GeneratePrivateKey()
+	from := privKey.PublicKey().Address()
+	to := crypto.GeneratePrivateKey().PublicKey().Address()
+
+	genesis, err := GenesisBlock(GenesisAlloc{from: 100})
+	assert.Nil(t, err)
+	bc, err := NewBlockchain(genesis, nil)
+	assert.Nil(t, err)
+
+	tx := NewTransferTransaction(to, 30)
+	assert.Nil(t, tx.Sign(privKey))
+	assert.Nil(t, bc.AddBlock(preparedBlock(t, bc, tx)))
+
+	acc, err := bc.GetAccount(from)
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(70), acc.Balance)
+	acc, err = bc.GetAccount(to)
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(30), acc.Balance)
+
+	// PrepareBlock drops the overspending transaction.
+	overspend := NewTransferTransaction(to, 71)
+	overspend.Nonce = 1
+	assert.Nil(t, overspend.Sign(privKey))
+	b := preparedBlock(t, bc, overspend)
+	assert.Equal(t, 0, len(b.Transactions))
+
+	// A block that includes it anyway is rejected.
+	b.Transactions = []*Transaction{overspend}
+	b.DataHash, err = CalculateDataHash(b.Transactions)
+	assert.Nil(t, err)
+	assert.Nil(t, b.Sign(privKey))
+	assert.NotNil(t, bc.AddBlock(b))
+	assert.Equal(t, uint32(1), bc.Height())
+}
+
+// preparedBlock builds a signed block with txx on top of the chain's head.
+func preparedBlock(t *testing.T, bc *Blockchain, txx ...*Transaction) *Block {
+	head, err := bc.GetHeader(bc.Height())
+	assert.Nil(t, err)
+
+	b, err := NewBlockFromPrevHeader(head, txx)
+	assert.Nil(t, err)
+	_, err = bc.PrepareBlock(b)
+	assert.Nil(t, err)
+	assert.Nil(t, b.Sign(crypto.GeneratePrivateKey()))
+
+	return b
+}
+
+func TestAddBlockNonces(t *testing.T) {
+	bc := newBlockchainWithGenesis(t)
+	privKey := crypto.GeneratePrivateKey()
+
+	tx := NewTransaction([]byte("foo"))
+	assert.Nil(t, tx.Sign(privKey))
+	assert.Nil(t, bc.AddBlock(preparedBlock(t, bc, tx)))
+
+	acc, err := bc.GetAccount(privKey.PublicKey().Address())
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(1), acc.Nonce)
+
+	// Replaying the same transaction carries a stale nonce.
+	replay := blockWithTxs(t, bc, tx)
+	assert.NotNil(t, bc.AddBlock(replay))
+
+	gapped := NewTransaction([]byte("foo"))
+	gapped.Nonce = 2
+	assert.Nil(t, gapped.Sign(privKey))
+	assert.NotNil(t, bc.AddBlock(blockWithTxs(t, bc, gapped)))
+
+	next := NewTransaction([]byte("foo"))
+	next.Nonce = 1
+	assert.Nil(t, next.Sign(privKey))
+	assert.Nil(t, bc.AddBlock(preparedBlock(t, bc, next)))
+	assert.Equal(t, uint32(2), bc.Height())
+}
+
+func TestAddBlockWrongChainID(t *testing.T) {
+	bc, err := NewBlockchain(randomBlockWithSignature(t, 0, types.Hash{}), &BlockchainOpts{ChainID: 7})
+	assert.Nil(t, err)
+
+	tx := NewTransaction([]byte("foo"))
+	tx.ChainID = 8
+	assert.Nil(t, tx.Sign(crypto.GeneratePrivateKey()))
+	assert.NotNil(t, bc.AddBlock(blockWithTxs(t, bc, tx)))
+
+	tx = NewTransaction([]byte("foo"))
+	tx.ChainID = 7
+	assert.Nil(t, tx.Sign(crypto.GeneratePrivateKey()))
+	assert.Nil(t, bc.AddBlock(preparedBlock(t, bc, tx)))
+}
+
+// blockWithTxs builds a signed block with txx on top of the chain's head
+// without executing them, so the state root and gas used are left unset.
+func blockWithTxs(t *testing.T, bc *Blockchain, txx ...*Transaction) *Block {
+	head, err := bc.GetHeader(bc.Height())
+	assert.Nil(t, err)
+
+	b, err := NewBlockFromPrevHeader(head, txx)
+	assert.Nil(t, err)
+	b.GasLimit = bc.BlockGasLimit()
+	assert.Nil(t, b.Sign(crypto.GeneratePrivateKey()))
+
+	return b
+}
+
+func TestAddBlockInvalidTxLeavesStateUntouched(t *testing.T) {
+	bc := newBlockchainWithGenesis(t)
+	root := bc.contractState.Root()
+
+	// The first transaction executes, the second has a stale nonce.
+	privKey := crypto.GeneratePrivateKey()
+	ok := NewTransaction(storeProgram('a', 1))
+	stale := NewTransaction(storeProgram('b', 1))
+	assert.Nil(t, ok.Sign(privKey))
+	assert.Nil(t, stale.Sign(privKey))
+
+	b := blockWithTxs(t, bc, ok, stale)
+	assert.NotNil(t, bc.AddBlock(b))
+	assert.Equal(t, root, bc.contractState.Root())
+	assert.Empty(t, bc.contractState.journal)
+}
+
+func TestAddBlockOutOfGas(t *testing.T) {
+	bc := newBlockchainWithGenesis(t)
+	privKey := crypto.GeneratePrivateKey()
+
+	data := storeProgram('a', 1)
+	tx := NewTransaction(data)
+	tx.GasLimit = tx.IntrinsicGas() + GasStore - 1
+	assert.Nil(t, tx.Sign(privKey))
+
+	b := preparedBlock(t, bc, tx)
+	assert.Equal(t, 1, len(b.Transactions))
+	assert.Equal(t, tx.GasLimit, b.GasUsed)
+	assert.Nil(t, bc.AddBlock(b))
+
+	// The write is reverted but the nonce is consumed.
+	_, err := senderStorage(bc.contractState, tx, "a")
+	assert.NotNil(t, err)
+	acc, err := bc.GetAccount(privKey.PublicKey().Address())
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(1), acc.Nonce)
+}
+
+func TestAddBlockGasLimit(t *testing.T) {
+	bc, err := NewBlockchain(randomBlockWithSignature(t, 0, types.Hash{}), &BlockchainOpts{BlockGasLimit: DefaultTxGasLimit + 2000})
+	assert.Nil(t, err)
+
+	txx := make([]*Transaction, 4)
+	for i := range txx {
+		txx[i] = NewTransaction(storeProgram(byte('a'+i), 1))
+		assert.Nil(t, txx[i].Sign(crypto.GeneratePrivateKey()))
+	}
+
+	// A transaction only fits if its whole gas limit does, so PrepareBlock
+	// leaves out the last two.
+	b := preparedBlock(t, bc, txx...)
+	assert.Equal(t, 2, len(b.Transactions))
+	assert.Equal(t, bc.BlockGasLimit(), b.GasLimit)
+
+	overfull := blockWithTxs(t, bc, txx...)
+	assert.NotNil(t, bc.AddBlock(overfull))
+
+	// The gas used must match what executing the block consumes.
+	b.GasUsed++
+	assert.Nil(t, b.Sign(crypto.GeneratePrivateKey()))
+	assert.NotNil(t, bc.AddBlock(b))
+
+	b.GasUsed--
+	assert.Nil(t, b.Sign(crypto.GeneratePrivateKey()))
+	assert.Nil(t, bc.AddBlock(b))
+	assert.Equal(t, uint32(1), bc.Height())
+}
+
+func TestPrepareBlockDroppedTxs(t *testing.T) {
+	bc, err := NewBlockchain(randomBlockWithSignature(t, 0, types.Hash{}), &BlockchainOpts{BlockGasLimit: DefaultTxGasLimit + 2000})
+	assert.Nil(t, err)
+
+	privKey := crypto.GeneratePrivateKey()
+	ok := NewTransaction(storeProgram('a', 1))
+	stale := NewTransaction(storeProgram('b', 1))
+	assert.Nil(t, ok.Sign(privKey))
+	assert.Nil(t, stale.Sign(privKey))
+	fits := NewTransaction(storeProgram('c', 1))
+	tooBig := NewTransaction(storeProgram('d', 1))
+	assert.Nil(t, fits.Sign(crypto.GeneratePrivateKey()))
+	assert.Nil(t, tooBig.Sign(crypto.GeneratePrivateKey()))
+
+	// Only the invalid transaction is reported, not the one left out for
+	// the gas limit.
+	b, err := NewBlockFromPrevHeader(bc.headers[0], []*Transaction{ok, stale, fits, tooBig})
+	assert.Nil(t, err)
+	invalid, err := bc.PrepareBlock(b)
+	assert.Nil(t, err)
+	assert.Equal(t, []*Transaction{stale}, invalid)
+	assert.Equal(t, []*Transaction{ok, fits}, b.Transactions)
+}
+
+func TestAddBlockRevertedTx(t *testing.T) {
+	bc := newBlockchainWithGenesis(t)
+
+	data := append(storeProgram('a', 1), program(pushBytes([]byte("no")), InstrRevert)...)
+	tx := NewTransaction(data)
+	assert.Nil(t, tx.Sign(crypto.GeneratePrivateKey()))
+
+	b := preparedBlock(t, bc, tx)
+	assert.Equal(t, 1, len(b.Transactions))
+	assert.Less(t, b.GasUsed, tx.GasLimit)
+	assert.Nil(t, bc.AddBlock(b))
+
+	_, err := senderStorage(bc.contractState, tx, "a")
+	assert.NotNil(t, err)
+
+	receipt, err := bc.GetReceipt(tx.Hash(TxHasher{}))
+	assert.Nil(t, err)
+	assert.Equal(t, ReceiptStatusFailed, receipt.Status)
+	assert.Equal(t, b.GasUsed, receipt.GasUsed)
+}
+
+func TestAddBlockFaultingTx(t *testing.T) {
+	bc := newBlockchainWithGenesis(t)
+	privKey := crypto.GeneratePrivateKey()
+
+	// Each program faults: stack underflow, a bad operand type and
+	// division by zero.
+	faulting := [][]byte{
+		program(InstrAdd),
+		program(pushInt(1), pushInt(1), InstrStore),
+		program(pushInt(0), pushInt(1), InstrDiv),
+	}
+	txx := []*Transaction{}
+	for i, data := range faulting {
+		tx := NewTransaction(append(storeProgram('a', 1), data...))
+		tx.Nonce = uint64(i)
+		assert.Nil(t, tx.Sign(privKey))
+		txx = append(txx, tx)
+	}
+	ok := NewTransaction(storeProgram('b', 1))
+	ok.Nonce = uint64(len(txx))
+	assert.Nil(t, ok.Sign(privKey))
+	txx = append(txx, ok)
+
+	b := preparedBlock(t, bc, txx...)
+	assert.Equal(t, len(txx), len(b.Transactions))
+	assert.Nil(t, bc.AddBlock(b))
+
+	receipts, err := bc.GetReceipts(b.Hash(BlockHasher{}))
+	assert.Nil(t, err)
+	assert.Equal(t, len(txx), len(receipts))
+	for i, receipt := range receipts[:len(faulting)] {
+		assert.Equal(t, txx[i].Hash(TxHasher{}), receipt.TxHash)
+		assert.Equal(t, ReceiptStatusFailed, receipt.Status)
+		assert.Equal(t, txx[i].GasLimit, receipt.GasUsed)
+		assert.NotEmpty(t, receipt.Error)
+	}
+	assert.Equal(t, ReceiptStatusSuccess, receipts[len(faulting)].Status)
+
+	_, err = senderStorage(bc.contractState, ok, "a")
+	assert.NotNil(t, err)
+	_, err = senderStorage(bc.contractState, ok, "b")
+	assert.Nil(t, err)
+}
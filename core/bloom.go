@@ -0,0 +1,61 @@
+package core
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+)
+
+const (
+	// BloomByteLength is the size of a Bloom filter in bytes.
+	BloomByteLength = 256
+	bloomBits       = BloomByteLength * 8
+	bloomHashes     = 3
+)
+
+// Bloom is a 2048 bit Bloom filter over the addresses and topics of the logs
+// emitted in a block. It lets log queries skip blocks that cannot contain a
+// match without reading their receipts.
+type Bloom [BloomByteLength]byte
+
+// Add sets the bits for data. Each of the first bloomHashes pairs of bytes
+// of sha256(data) selects one bit.
+func (b *Bloom) Add(data []byte) {
+	for _, bit := range bloomBitsOf(data) {
+		b[bit/8] |= 1 << (bit % 8)
+	}
+}
+
+// Test reports whether data may have been added. False positives are
+// possible, false negatives are not.
+func (b *Bloom) Test(data []byte) bool {
+	for _, bit := range bloomBitsOf(data) {
+		if b[bit/8]&(1<<(bit%8)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func bloomBitsOf(data []byte) [bloomHashes]uint {
+	h := sha256.Sum256(data)
+
+	var bits [bloomHashes]uint
+	for i := range bits {
+		bits[i] = uint(binary.BigEndian.Uint16(h[2*i:])) % bloomBits
+	}
+	return bits
+}
+
+// CreateBloom returns the Bloom filter over the logs of receipts.
+func CreateBloom(receipts []*Receipt) Bloom {
+	var bloom Bloom
+	for _, r := range receipts {
+		for _, l := range r.Logs {
+			bloom.Add(l.Address[:])
+			for _, topic := range l.Topics {
+				bloom.Add(topic[:])
+			}
+		}
+	}
+	return bloom
+}
@@ -0,0 +1,68 @@
+package core
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// immediateSizes holds the size of the immediate that follows each push
+// instruction in the bytecode. Integers are little endian; InstrPushBytes is
+// followed by the length of its data as a uint32 and then the data itself.
+var immediateSizes = map[Instruction]int{
+	InstrPushInt:   1,
+	InstrPushByte:  1,
+	InstrPushInt32: 4,
+	InstrPushInt64: 8,
+	InstrPushBytes: 4,
+}
+
+// immediateSize returns the size of the immediate of the instruction at pc.
+// It reports false if the code ends before the immediate does.
+func immediateSize(code []byte, pc int) (int, bool) {
+	instr := Instruction(code[pc])
+	n := immediateSizes[instr]
+	rest := len(code) - pc - 1
+	if rest < n {
+		return 0, false
+	}
+	if instr == InstrPushBytes {
+		length := binary.LittleEndian.Uint32(code[pc+1:])
+		if uint64(rest-n) < uint64(length) {
+			return 0, false
+		}
+		n += int(length)
+	}
+	return n, true
+}
+
+// ValidateCode checks that code only holds valid instructions and that
+// every immediate is complete. Programs are validated before they run, so a
+// truncated program never executes at all.
+func ValidateCode(code []byte) error {
+	_, err := analyzeCode(code)
+	return err
+}
+
+// analyzeCode validates code and returns which offsets can be jumped to:
+// those holding an InstrJumpDest instruction, as opposed to an immediate
+// that happens to have the same value.
+func analyzeCode(code []byte) ([]bool, error) {
+	jumpdests := make([]bool, len(code))
+
+	for pc := 0; pc < len(code); {
+		instr := Instruction(code[pc])
+		if !instr.Valid() {
+			return nil, &VMError{Instr: instr, PC: pc, Err: ErrInvalidOpcode}
+		}
+		n, ok := immediateSize(code, pc)
+		if !ok {
+			return nil, &VMError{Instr: instr, PC: pc, Err: fmt.Errorf("%w: truncated immediate", ErrInvalidCode)}
+		}
+		if instr == InstrJumpDest {
+			jumpdests[pc] = true
+		}
+		pc += 1 + n
+	}
+
+	return jumpdests, nil
+}
@@ -0,0 +1,62 @@
+package core
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+
+	"github.com/3ssalunke/go-blockchain/types"
+)
+
+const (
+	codeKeyPrefix    = "code/"
+	storageKeyPrefix = "storage/"
+)
+
+// ContractAddress returns the address of the contract deployed by sender in
+// its transaction with the given nonce. Since a nonce is only ever used once
+// the address is unique, and anyone can compute it before the deploy
+// transaction is included.
+func ContractAddress(sender types.Address, nonce uint64) types.Address {
+	buf := make([]byte, 0, 28)
+	buf = append(buf, sender.ToSlice()...)
+	buf = binary.LittleEndian.AppendUint64(buf, nonce)
+	h := sha256.Sum256(buf)
+
+	return types.AddressFromBytes(h[len(h)-20:])
+}
+
+// GetCode returns the code of the contract at addr.
+func (s *State) GetCode(addr types.Address) ([]byte, error) {
+	code, ok := s.data[codeKey(addr)]
+	if !ok {
+		return nil, fmt.Errorf("no contract at address %s", addr)
+	}
+	return code, nil
+}
+
+func (s *State) PutCode(addr types.Address, code []byte) error {
+	return s.Put([]byte(codeKey(addr)), code)
+}
+
+// GetStorage reads key from the storage of addr. Every address has its own
+// storage, so contracts cannot read or overwrite each other's keys.
+func (s *State) GetStorage(addr types.Address, key []byte) ([]byte, error) {
+	return s.Get([]byte(storageKey(addr, key)))
+}
+
+func (s *State) PutStorage(addr types.Address, key, value []byte) error {
+	return s.Put([]byte(storageKey(addr, key)), value)
+}
+
+func (s *State) DeleteStorage(addr types.Address, key []byte) error {
+	return s.Delete([]byte(storageKey(addr, key)))
+}
+
+func codeKey(addr types.Address) string {
+	return codeKeyPrefix + string(addr.ToSlice())
+}
+
+func storageKey(addr types.Address, key []byte) string {
+	return storageKeyPrefix + string(addr.ToSlice()) + string(key)
+}
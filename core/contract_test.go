@@ -0,0 +1,136 @@
+package core
+
+import (
+	"testing"
+
+	"github.com/3ssalunke/go-blockchain/crypto"
+	"github.com/3ssalunke/go-blockchain/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestContractAddress(t *testing.T) {
+	sender := crypto.GeneratePrivateKey().PublicKey().Address()
+
+	assert.Equal(t, ContractAddress(sender, 0), ContractAddress(sender, 0))
+	assert.NotEqual(t, ContractAddress(sender, 0), ContractAddress(sender, 1))
+}
+
+func TestDeployAndCall(t *testing.T) {
+	bc := newBlockchainWithGenesis(t)
+	privKey := crypto.GeneratePrivateKey()
+	sender := privKey.PublicKey().Address()
+
+	// The contract stores its input under "v".
+	code := program(InstrCallData, pushBytes([]byte("v")), InstrStore)
+	deployA := NewDeployTransaction(code)
+	deployB := NewDeployTransaction(code)
+	deployB.Nonce = 1
+	assert.Nil(t, deployA.Sign(privKey))
+	assert.Nil(t, deployB.Sign(privKey))
+	assert.Nil(t, bc.AddBlock(preparedBlock(t, bc, deployA, deployB)))
+
+	addrA, addrB := ContractAddress(sender, 0), ContractAddress(sender, 1)
+	stored, err := bc.GetCode(addrA)
+	assert.Nil(t, err)
+	assert.Equal(t, code, stored)
+
+	callA := NewCallTransaction(addrA, []byte("a"))
+	callA.Nonce = 2
+	callB := NewCallTransaction(addrB, []byte("b"))
+	callB.Nonce = 3
+	assert.Nil(t, callA.Sign(privKey))
+	assert.Nil(t, callB.Sign(privKey))
+	assert.Nil(t, bc.AddBlock(preparedBlock(t, bc, callA, callB)))
+
+	// Both contracts write the same key, each to its own storage.
+	value, err := bc.contractState.GetStorage(addrA, []byte("v"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("a"), value)
+	value, err = bc.contractState.GetStorage(addrB, []byte("v"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("b"), value)
+}
+
+func TestCallInvalid(t *testing.T) {
+	bc := newBlockchainWithGenesis(t)
+
+	// Calling an address without code is invalid.
+	call := NewCallTransaction(types.AddressFromBytes(types.RandomBytes(20)), nil)
+	assert.Nil(t, call.Sign(crypto.GeneratePrivateKey()))
+	b := preparedBlock(t, bc, call)
+	assert.Equal(t, 0, len(b.Transactions))
+
+	// So is deploying no code or code that does not validate.
+	for _, code := range [][]byte{nil, program(InstrPushInt32, []byte{1})} {
+		deploy := NewDeployTransaction(code)
+		assert.Nil(t, deploy.Sign(crypto.GeneratePrivateKey()))
+		b = preparedBlock(t, bc, deploy)
+		assert.Equal(t, 0, len(b.Transactions))
+	}
+}
+
+func TestCallValue(t *testing.T) {
+	privKey := crypto.GeneratePrivateKey()
+	sender := privKey.PublicKey().Address()
+	genesis, err := GenesisBlock(GenesisAlloc{sender: 100})
+	assert.Nil(t, err)
+	bc, err := NewBlockchain(genesis, nil)
+	assert.Nil(t, err)
+
+	deployOk := NewDeployTransaction(program(InstrJumpDest))
+	deployFault := NewDeployTransaction(program(InstrAdd))
+	deployFault.Nonce = 1
+	assert.Nil(t, deployOk.Sign(privKey))
+	assert.Nil(t, deployFault.Sign(privKey))
+	assert.Nil(t, bc.AddBlock(preparedBlock(t, bc, deployOk, deployFault)))
+
+	callOk := NewCallTransaction(ContractAddress(sender, 0), nil)
+	callOk.Value = 30
+	callOk.Nonce = 2
+	callFault := NewCallTransaction(ContractAddress(sender, 1), nil)
+	callFault.Value = 30
+	callFault.Nonce = 3
+	assert.Nil(t, callOk.Sign(privKey))
+	assert.Nil(t, callFault.Sign(privKey))
+	b := preparedBlock(t, bc, callOk, callFault)
+	assert.Equal(t, 2, len(b.Transactions))
+	assert.Nil(t, bc.AddBlock(b))
+
+	// The value sent along with the faulting call is returned.
+	acc, err := bc.GetAccount(sender)
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(70), acc.Balance)
+	acc, err = bc.GetAccount(ContractAddress(sender, 0))
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(30), acc.Balance)
+}
+
+func TestDeployValue(t *testing.T) {
+	privKey := crypto.GeneratePrivateKey()
+	sender := privKey.PublicKey().Address()
+	genesis, err := GenesisBlock(GenesisAlloc{sender: 100})
+	assert.Nil(t, err)
+	bc, err := NewBlockchain(genesis, nil)
+	assert.Nil(t, err)
+
+	deploy := NewDeployTransaction(program(InstrJumpDest))
+	deploy.Value = 40
+	assert.Nil(t, deploy.Sign(privKey))
+	assert.Nil(t, bc.AddBlock(preparedBlock(t, bc, deploy)))
+
+	acc, err := bc.GetAccount(sender)
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(60), acc.Balance)
+	acc, err = bc.GetAccount(ContractAddress(sender, 0))
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(40), acc.Balance)
+
+	// A deploy sending more than the sender has is invalid.
+	overdrawn := NewDeployTransaction(program(InstrJumpDest))
+	overdrawn.Value = 61
+	overdrawn.Nonce = 1
+	assert.Nil(t, overdrawn.Sign(privKey))
+	assert.Equal(t, 0, len(preparedBlock(t, bc, overdrawn).Transactions))
+	_, err = bc.GetCode(ContractAddress(sender, 1))
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,97 @@
+package core
+
+import (
+	"fmt"
+
+	"github.com/3ssalunke/go-blockchain/types"
+)
+
+// CallMsg describes an execution that is not backed by a transaction. With
+// Code set, the code runs with the storage of From like an exec transaction;
+// otherwise the contract at To is called with Input like a call
+// transaction.
+type CallMsg struct {
+	From  types.Address
+	To    types.Address
+	Code  []byte
+	Input []byte
+	Value uint64
+	// GasLimit defaults to DefaultTxGasLimit and may not exceed the block
+	// gas limit.
+	GasLimit uint64
+}
+
+// DryRunResult is the outcome of DryRun. Err is set if the code faulted, in
+// which case there are no logs nor state changes, just as for a
+// transaction.
+type DryRunResult struct {
+	ReturnValue []byte
+	// StackTop is the value left on top of the stack, or nil if the stack
+	// is empty.
+	StackTop *Value
+	GasUsed  uint64
+	Logs     []*Log
+	Diff     []StateDiff
+	Err      error
+}
+
+// DryRun executes msg against a copy of the state after the canonical block
+// at height, which must be at most MaxStateHistory blocks below the head,
+// and reports what it would do. The chain and its state are left untouched.
+// An error means msg could not run at all, e.g. because To holds no code.
+func (bc *Blockchain) DryRun(msg *CallMsg, height uint32) (*DryRunResult, error) {
+	gasLimit := msg.GasLimit
+	if gasLimit == 0 {
+		gasLimit = DefaultTxGasLimit
+	}
+	if gasLimit > bc.blockGasLimit {
+		return nil, fmt.Errorf("gas limit %d exceeds the block gas limit %d", gasLimit, bc.blockGasLimit)
+	}
+
+	state, err := bc.stateAt(func() (*BlockNode, error) {
+		node := bc.head.Ancestor(height)
+		if node == nil {
+			return nil, fmt.Errorf("given height (%d) is too high", height)
+		}
+		return node, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	addr, code := msg.From, msg.Code
+	if len(code) == 0 {
+		var err error
+		if code, err = state.GetCode(msg.To); err != nil {
+			return nil, err
+		}
+		addr = msg.To
+	}
+
+	if msg.Value > 0 {
+		if err := state.Transfer(msg.From, addr, msg.Value); err != nil {
+			return nil, err
+		}
+	}
+
+	vm := NewVM(code, state, gasLimit)
+	vm.address = addr
+	vm.caller = msg.From
+	vm.input = msg.Input
+
+	err = vm.Run()
+	result := &DryRunResult{
+		ReturnValue: vm.ReturnValue(),
+		GasUsed:     vm.GasUsed(),
+		Err:         err,
+	}
+	if top, err := vm.stack.Peek(); err == nil {
+		result.StackTop = &top
+	}
+	if err == nil {
+		result.Logs = vm.Logs()
+		result.Diff = state.Diff(0)
+	}
+
+	return result, nil
+}
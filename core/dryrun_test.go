@@ -0,0 +1,94 @@
+package core
+
+import (
+	"testing"
+
+	"github.com/3ssalunke/go-blockchain/crypto"
+	"github.com/3ssalunke/go-blockchain/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDryRun(t *testing.T) {
+	bc := newBlockchainWithGenesis(t)
+	privKey := crypto.GeneratePrivateKey()
+	sender := privKey.PublicKey().Address()
+
+	// The contract returns what it stored under "v" and then stores its
+	// input there.
+	code := program(pushBytes([]byte("v")), InstrGet, InstrCallData, pushBytes([]byte("v")), InstrStore, InstrReturn)
+	deploy := NewDeployTransaction(code)
+	assert.Nil(t, deploy.Sign(privKey))
+	assert.Nil(t, bc.AddBlock(preparedBlock(t, bc, deploy)))
+	addr := ContractAddress(sender, 0)
+
+	call := NewCallTransaction(addr, []byte("a"))
+	call.Nonce = 1
+	assert.Nil(t, call.Sign(privKey))
+	assert.Nil(t, bc.AddBlock(preparedBlock(t, bc, call)))
+	root := bc.contractState.Root()
+
+	msg := &CallMsg{From: sender, To: addr, Input: []byte("b")}
+	result, err := bc.DryRun(msg, bc.Height())
+	assert.Nil(t, err)
+	assert.Nil(t, result.Err)
+	assert.Equal(t, []byte("a"), result.ReturnValue)
+	assert.Equal(t, 2*GasFast+2*GasCopyByte+GasGet+GasQuick+GasStore+2*GasStoreByte+GasQuick, result.GasUsed)
+	assert.Equal(t, []StateDiff{{
+		Key:    []byte(storageKey(addr, []byte("v"))),
+		Before: []byte("a"),
+		After:  []byte("b"),
+	}}, result.Diff)
+
+	// Before the call the contract had stored nothing.
+	result, err = bc.DryRun(msg, 1)
+	assert.Nil(t, err)
+	assert.Equal(t, []byte{}, result.ReturnValue)
+	assert.Nil(t, result.Diff[0].Before)
+
+	assert.Equal(t, root, bc.contractState.Root())
+	value, err := bc.contractState.GetStorage(addr, []byte("v"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("a"), value)
+
+	_, err = bc.DryRun(msg, bc.Height()+1)
+	assert.NotNil(t, err)
+	_, err = bc.DryRun(&CallMsg{To: types.AddressFromBytes(types.RandomBytes(20))}, bc.Height())
+	assert.NotNil(t, err)
+}
+
+func TestDryRunStateHistory(t *testing.T) {
+	bc := newBlockchainWithGenesis(t)
+	for i := 0; i < MaxStateHistory+1; i++ {
+		assert.Nil(t, bc.AddBlock(preparedBlock(t, bc)))
+	}
+
+	msg := &CallMsg{Code: program(pushInt(1))}
+	_, err := bc.DryRun(msg, 0)
+	assert.NotNil(t, err)
+	_, err = bc.DryRun(msg, 1)
+	assert.Nil(t, err)
+}
+
+func TestDryRunCode(t *testing.T) {
+	bc := newBlockchainWithGenesis(t)
+
+	result, err := bc.DryRun(&CallMsg{Code: program(pushInt(1), pushInt(3), InstrAdd)}, 0)
+	assert.Nil(t, err)
+	assert.Nil(t, result.Err)
+	assert.Equal(t, IntValue(4), *result.StackTop)
+	assert.Empty(t, result.Diff)
+
+	// A fault keeps no changes.
+	result, err = bc.DryRun(&CallMsg{Code: program(storeProgram('a', 1), InstrAdd)}, 0)
+	assert.Nil(t, err)
+	assert.ErrorIs(t, result.Err, ErrStackUnderflow)
+	assert.Empty(t, result.Diff)
+
+	result, err = bc.DryRun(&CallMsg{Code: storeProgram('a', 1), GasLimit: GasStore}, 0)
+	assert.Nil(t, err)
+	assert.ErrorIs(t, result.Err, ErrOutOfGas)
+	assert.Equal(t, GasStore, result.GasUsed)
+
+	_, err = bc.DryRun(&CallMsg{Code: storeProgram('a', 1), GasLimit: bc.BlockGasLimit() + 1}, 0)
+	assert.NotNil(t, err)
+}
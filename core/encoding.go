@@ -1,8 +1,14 @@
 package core
 
 import (
-	"encoding/gob"
+	"bytes"
+	"fmt"
 	"io"
+	"math/big"
+
+	"github.com/3ssalunke/go-blockchain/crypto"
+	"github.com/3ssalunke/go-blockchain/types"
+	"github.com/3ssalunke/go-blockchain/util"
 )
 
 type Encoder[T any] interface {
@@ -13,62 +19,297 @@ type Decoder[T any] interface {
 	Decode(T) error
 }
 
-type GobTxEncoder struct {
+// CodecVersion is written at the start of every binary encoding so the
+// format can evolve without ambiguity.
+const CodecVersion uint8 = 4
+
+// maxBlockTxs bounds the transaction count read from a block encoding.
+const maxBlockTxs = 1 << 16
+
+// maxLogs and maxLogTopics bound the logs read from a receipt encoding.
+const (
+	maxLogs      = 1 << 12
+	maxLogTopics = 4
+)
+
+type BinaryTxEncoder struct {
 	w io.Writer
 }
 
-func NewGobTxEncoder(w io.Writer) *GobTxEncoder {
-	return &GobTxEncoder{
+func NewBinaryTxEncoder(w io.Writer) *BinaryTxEncoder {
+	return &BinaryTxEncoder{
 		w: w,
 	}
 }
 
-func (e *GobTxEncoder) Encode(tx *Transaction) error {
-	enc := gob.NewEncoder(e.w)
-	return enc.Encode(tx)
+func (e *BinaryTxEncoder) Encode(tx *Transaction) error {
+	w := util.NewBinaryWriter()
+	w.WriteUint8(CodecVersion)
+	writeTx(w, tx)
+
+	_, err := e.w.Write(w.Bytes())
+	return err
 }
 
-type GobTxDecoder struct {
-	r io.Reader
+type BinaryTxDecoder struct {
+	r *util.BinaryReader
 }
 
-func NewGobTxDecoder(r io.Reader) *GobTxDecoder {
-	return &GobTxDecoder{
-		r: r,
+func NewBinaryTxDecoder(r io.Reader) *BinaryTxDecoder {
+	return &BinaryTxDecoder{
+		r: util.NewBinaryReader(r),
 	}
 }
 
-func (d *GobTxDecoder) Decode(tx *Transaction) error {
-	dec := gob.NewDecoder(d.r)
-	return dec.Decode(tx)
+func (d *BinaryTxDecoder) Decode(tx *Transaction) error {
+	if err := readCodecVersion(d.r); err != nil {
+		return err
+	}
+	readTx(d.r, tx)
+	d.r.ReadEOF()
+	return d.r.Err()
 }
 
-type GobBlockEncoder struct {
+type BinaryBlockEncoder struct {
 	w io.Writer
 }
 
-func NewGobBlockEncoder(w io.Writer) *GobBlockEncoder {
-	return &GobBlockEncoder{
+func NewBinaryBlockEncoder(w io.Writer) *BinaryBlockEncoder {
+	return &BinaryBlockEncoder{
 		w: w,
 	}
 }
 
-func (e *GobBlockEncoder) Encode(b *Block) error {
-	enc := gob.NewEncoder(e.w)
-	return enc.Encode(b)
+func (e *BinaryBlockEncoder) Encode(b *Block) error {
+	w := util.NewBinaryWriter()
+	w.WriteUint8(CodecVersion)
+	writeBlock(w, b)
+
+	_, err := e.w.Write(w.Bytes())
+	return err
+}
+
+type BinaryBlockDecoder struct {
+	r *util.BinaryReader
+}
+
+func NewBinaryBlockDecoder(r io.Reader) *BinaryBlockDecoder {
+	return &BinaryBlockDecoder{
+		r: util.NewBinaryReader(r),
+	}
+}
+
+func (d *BinaryBlockDecoder) Decode(b *Block) error {
+	if err := readCodecVersion(d.r); err != nil {
+		return err
+	}
+	readBlock(d.r, b)
+	d.r.ReadEOF()
+	return d.r.Err()
+}
+
+func readCodecVersion(r *util.BinaryReader) error {
+	version := r.ReadUint8()
+	if err := r.Err(); err != nil {
+		return err
+	}
+	if version != CodecVersion {
+		return fmt.Errorf("unsupported codec version %d", version)
+	}
+	return nil
+}
+
+func writeHeader(w *util.BinaryWriter, h *Header) {
+	w.WriteUint32(h.Version)
+	w.WriteFixed(h.DataHash[:])
+	w.WriteFixed(h.PrevBlockHash[:])
+	w.WriteFixed(h.StateRoot[:])
+	w.WriteFixed(h.ReceiptsRoot[:])
+	w.WriteFixed(h.LogsBloom[:])
+	w.WriteUint64(h.GasLimit)
+	w.WriteUint64(h.GasUsed)
+	w.WriteInt64(h.Timestamp)
+	w.WriteUint32(h.Height)
+}
+
+func readHeader(r *util.BinaryReader, h *Header) {
+	h.Version = r.ReadUint32()
+	copy(h.DataHash[:], r.ReadFixed(32))
+	copy(h.PrevBlockHash[:], r.ReadFixed(32))
+	copy(h.StateRoot[:], r.ReadFixed(32))
+	copy(h.ReceiptsRoot[:], r.ReadFixed(32))
+	copy(h.LogsBloom[:], r.ReadFixed(BloomByteLength))
+	h.GasLimit = r.ReadUint64()
+	h.GasUsed = r.ReadUint64()
+	h.Timestamp = r.ReadInt64()
+	h.Height = r.ReadUint32()
+}
+
+// writeTxFields writes the fields covered by the transaction's signature.
+func writeTxFields(w *util.BinaryWriter, tx *Transaction) {
+	w.WriteUint32(tx.ChainID)
+	w.WriteUint64(tx.Nonce)
+	w.WriteUint64(tx.GasLimit)
+	w.WriteUint8(uint8(tx.Type))
+	w.WriteBytes(tx.From)
+	w.WriteFixed(tx.To[:])
+	w.WriteUint64(tx.Value)
+	w.WriteBytes(tx.Data)
+}
+
+func writeTx(w *util.BinaryWriter, tx *Transaction) {
+	writeTxFields(w, tx)
+	writeSignature(w, tx.Signature)
+}
+
+func readTx(r *util.BinaryReader, tx *Transaction) {
+	tx.ChainID = r.ReadUint32()
+	tx.Nonce = r.ReadUint64()
+	tx.GasLimit = r.ReadUint64()
+	tx.Type = TxType(r.ReadUint8())
+	tx.From = r.ReadBytes()
+	copy(tx.To[:], r.ReadFixed(20))
+	tx.Value = r.ReadUint64()
+	tx.Data = r.ReadBytes()
+	tx.Signature = readSignature(r)
+}
+
+func writeSignature(w *util.BinaryWriter, sig *crypto.Signature) {
+	if sig == nil {
+		w.WriteBool(false)
+		return
+	}
+	w.WriteBool(true)
+	w.WriteBytes(sig.R.Bytes())
+	w.WriteBytes(sig.S.Bytes())
+}
+
+func readSignature(r *util.BinaryReader) *crypto.Signature {
+	if !r.ReadBool() {
+		return nil
+	}
+	return &crypto.Signature{
+		R: new(big.Int).SetBytes(r.ReadBytes()),
+		S: new(big.Int).SetBytes(r.ReadBytes()),
+	}
+}
+
+func writeBlock(w *util.BinaryWriter, b *Block) {
+	writeHeader(w, b.Header)
+
+	w.WriteUint32(uint32(len(b.Transactions)))
+	for _, tx := range b.Transactions {
+		txw := util.NewBinaryWriter()
+		writeTx(txw, tx)
+		w.WriteBytes(txw.Bytes())
+	}
+
+	w.WriteBytes(b.Validator)
+	writeSignature(w, b.Signature)
 }
 
-type GobBlockDecoder struct {
-	r io.Reader
+func readBlock(r *util.BinaryReader, b *Block) {
+	b.Header = new(Header)
+	readHeader(r, b.Header)
+
+	n := r.ReadUint32()
+	if n > maxBlockTxs {
+		r.Fail(fmt.Errorf("block has %d transactions, maximum is %d", n, maxBlockTxs))
+		return
+	}
+	b.Transactions = make([]*Transaction, 0, n)
+	for i := uint32(0); i < n && r.Err() == nil; i++ {
+		txr := util.NewBinaryReader(bytes.NewReader(r.ReadBytes()))
+		tx := new(Transaction)
+		readTx(txr, tx)
+		txr.ReadEOF()
+		if err := txr.Err(); err != nil {
+			r.Fail(err)
+			return
+		}
+		b.Transactions = append(b.Transactions, tx)
+	}
+
+	b.Validator = r.ReadBytes()
+	b.Signature = readSignature(r)
 }
 
-func NewGobBlockDecoder(r io.Reader) *GobBlockDecoder {
-	return &GobBlockDecoder{
-		r: r,
+// writeReceiptFields writes the fields covered by the receipt hash.
+func writeReceiptFields(w *util.BinaryWriter, rc *Receipt) {
+	w.WriteFixed(rc.TxHash[:])
+	w.WriteUint8(uint8(rc.Status))
+	w.WriteUint64(rc.GasUsed)
+
+	w.WriteUint32(uint32(len(rc.Logs)))
+	for _, l := range rc.Logs {
+		w.WriteFixed(l.Address[:])
+		w.WriteUint8(uint8(len(l.Topics)))
+		for _, topic := range l.Topics {
+			w.WriteFixed(topic[:])
+		}
+		w.WriteBytes(l.Data)
 	}
 }
 
-func (d *GobBlockDecoder) Decode(b *Block) error {
-	dec := gob.NewDecoder(d.r)
-	return dec.Decode(b)
+func writeReceipt(w *util.BinaryWriter, rc *Receipt) {
+	writeReceiptFields(w, rc)
+	w.WriteString(rc.Error)
+}
+
+func readReceipt(r *util.BinaryReader, rc *Receipt) {
+	copy(rc.TxHash[:], r.ReadFixed(32))
+	rc.Status = ReceiptStatus(r.ReadUint8())
+	rc.GasUsed = r.ReadUint64()
+
+	n := r.ReadUint32()
+	if n > maxLogs {
+		r.Fail(fmt.Errorf("receipt has %d logs, maximum is %d", n, maxLogs))
+		return
+	}
+	for i := uint32(0); i < n && r.Err() == nil; i++ {
+		l := new(Log)
+		copy(l.Address[:], r.ReadFixed(20))
+		topics := r.ReadUint8()
+		if topics > maxLogTopics {
+			r.Fail(fmt.Errorf("log has %d topics, maximum is %d", topics, maxLogTopics))
+			return
+		}
+		for j := uint8(0); j < topics; j++ {
+			var topic types.Hash
+			copy(topic[:], r.ReadFixed(32))
+			l.Topics = append(l.Topics, topic)
+		}
+		l.Data = r.ReadBytes()
+		rc.Logs = append(rc.Logs, l)
+	}
+
+	rc.Error = r.ReadString()
+}
+
+// writeReceipts writes the receipts of the block with the given hash.
+func writeReceipts(w *util.BinaryWriter, blockHash types.Hash, receipts []*Receipt) {
+	w.WriteFixed(blockHash[:])
+	w.WriteUint32(uint32(len(receipts)))
+	for _, rc := range receipts {
+		writeReceipt(w, rc)
+	}
+}
+
+func readReceipts(r *util.BinaryReader) (types.Hash, []*Receipt) {
+	var blockHash types.Hash
+	copy(blockHash[:], r.ReadFixed(32))
+
+	n := r.ReadUint32()
+	if n > maxBlockTxs {
+		r.Fail(fmt.Errorf("block has %d receipts, maximum is %d", n, maxBlockTxs))
+		return blockHash, nil
+	}
+	receipts := make([]*Receipt, 0, n)
+	for i := uint32(0); i < n && r.Err() == nil; i++ {
+		rc := new(Receipt)
+		readReceipt(r, rc)
+		receipts = append(receipts, rc)
+	}
+
+	return blockHash, receipts
 }
@@ -0,0 +1,174 @@
+package core
+
+import (
+	"bytes"
+	"encoding/hex"
+	"math/big"
+	"strings"
+	"testing"
+
+	"github.com/3ssalunke/go-blockchain/crypto"
+	"github.com/3ssalunke/go-blockchain/types"
+	"github.com/3ssalunke/go-blockchain/util"
+	"github.com/stretchr/testify/assert"
+)
+
+// The golden vectors pin the canonical encoding byte for byte. Any change to
+// them is a consensus change and requires bumping CodecVersion.
+var (
+	goldenHeaderHex = "04" + "01000000" +
+		"0101010101010101010101010101010101010101010101010101010101010101" +
+		"0202020202020202020202020202020202020202020202020202020202020202" +
+		"0303030303030303030303030303030303030303030303030303030303030303" +
+		"0606060606060606060606060606060606060606060606060606060606060606" +
+		strings.Repeat("07", BloomByteLength) +
+		"40420f0000000000" + "3075000000000000" +
+		"00002a36fe9c9717" + "2a000000"
+	goldenHeaderHash = "960bd3649d89d05d9bbe188459d1d00c31e215109e303cccdc31fb5334ffc243"
+
+	goldenTxHex = "04" + "01000000" + "0200000000000000" + "1027000000000000" + "01" +
+		"21000000" + "040404040404040404040404040404040404040404040404040404040404040404" +
+		"0505050505050505050505050505050505050505" + "e803000000000000" +
+		"03000000" + "616263" +
+		"01" + "01000000" + "07" + "01000000" + "09"
+	goldenTxHash = "2d42671c81a80d1c2ce28d0f72c16a4683c59a8f9a7c51bc08e493192f6fe2d2"
+)
+
+var goldenBlockHex = "04" + goldenHeaderHex[2:] +
+	"01000000" + "68000000" + goldenTxHex[2:] +
+	"21000000" + "040404040404040404040404040404040404040404040404040404040404040404" +
+	"01" + "01000000" + "0b" + "01000000" + "0d"
+
+func goldenHeader() *Header {
+	return &Header{
+		Version:       1,
+		DataHash:      types.HashFromBytes(bytes.Repeat([]byte{0x01}, 32)),
+		PrevBlockHash: types.HashFromBytes(bytes.Repeat([]byte{0x02}, 32)),
+		StateRoot:     types.HashFromBytes(bytes.Repeat([]byte{0x03}, 32)),
+		ReceiptsRoot:  types.HashFromBytes(bytes.Repeat([]byte{0x06}, 32)),
+		LogsBloom:     Bloom(bytes.Repeat([]byte{0x07}, BloomByteLength)),
+		GasLimit:      1000000,
+		GasUsed:       30000,
+		Timestamp:     1700000000000000000,
+		Height:        42,
+	}
+}
+
+func goldenTx() *Transaction {
+	return &Transaction{
+		ChainID:   1,
+		Nonce:     2,
+		GasLimit:  10000,
+		Type:      TxTypeTransfer,
+		From:      bytes.Repeat([]byte{0x04}, 33),
+		To:        types.AddressFromBytes(bytes.Repeat([]byte{0x05}, 20)),
+		Value:     1000,
+		Data:      []byte("abc"),
+		Signature: &crypto.Signature{R: big.NewInt(7), S: big.NewInt(9)},
+	}
+}
+
+func goldenBlock() *Block {
+	return &Block{
+		Header:       goldenHeader(),
+		Transactions: []*Transaction{goldenTx()},
+		Validator:    bytes.Repeat([]byte{0x04}, 33),
+		Signature:    &crypto.Signature{R: big.NewInt(11), S: big.NewInt(13)},
+	}
+}
+
+func TestHeaderGoldenVector(t *testing.T) {
+	h := goldenHeader()
+
+	assert.Equal(t, goldenHeaderHex, hex.EncodeToString(h.Bytes()))
+	assert.Equal(t, goldenHeaderHash, BlockHasher{}.Hash(h).String())
+}
+
+func TestTxGoldenVector(t *testing.T) {
+	tx := goldenTx()
+
+	buf := &bytes.Buffer{}
+	assert.Nil(t, tx.Encode(NewBinaryTxEncoder(buf)))
+	assert.Equal(t, goldenTxHex, hex.EncodeToString(buf.Bytes()))
+	assert.Equal(t, goldenTxHash, TxHasher{}.Hash(tx).String())
+
+	decoded := new(Transaction)
+	assert.Nil(t, decoded.Decode(NewBinaryTxDecoder(buf)))
+	assert.Equal(t, tx, decoded)
+}
+
+func TestBlockGoldenVector(t *testing.T) {
+	b := goldenBlock()
+
+	buf := &bytes.Buffer{}
+	assert.Nil(t, b.Encode(NewBinaryBlockEncoder(buf)))
+	assert.Equal(t, goldenBlockHex, hex.EncodeToString(buf.Bytes()))
+
+	decoded := new(Block)
+	assert.Nil(t, decoded.Decode(NewBinaryBlockDecoder(buf)))
+	assert.Equal(t, b.Header, decoded.Header)
+	assert.Equal(t, b.Transactions, decoded.Transactions)
+	assert.Equal(t, goldenHeaderHash, decoded.Hash(BlockHasher{}).String())
+}
+
+func TestBinaryBlockEncodeDecode(t *testing.T) {
+	b := randomBlockWithSignature(t, 3, types.RandomHash())
+
+	buf := &bytes.Buffer{}
+	assert.Nil(t, b.Encode(NewBinaryBlockEncoder(buf)))
+	raw := buf.Bytes()
+
+	decoded := new(Block)
+	assert.Nil(t, decoded.Decode(NewBinaryBlockDecoder(bytes.NewReader(raw))))
+	assert.Equal(t, b.Hash(BlockHasher{}), decoded.Hash(BlockHasher{}))
+	assert.Nil(t, decoded.Verify())
+
+	// A decoder reads exactly one block.
+	twice := append(append([]byte{}, raw...), raw...)
+	assert.NotNil(t, new(Block).Decode(NewBinaryBlockDecoder(bytes.NewReader(twice))))
+}
+
+func TestBinaryDecodeRejectsBadInput(t *testing.T) {
+	raw, err := hex.DecodeString(goldenTxHex)
+	assert.Nil(t, err)
+
+	tx := new(Transaction)
+	assert.NotNil(t, tx.Decode(NewBinaryTxDecoder(bytes.NewReader(raw[:len(raw)-1]))))
+	assert.NotNil(t, tx.Decode(NewBinaryTxDecoder(bytes.NewReader(append(raw, 0x00)))))
+
+	raw[0] = CodecVersion + 1
+	assert.NotNil(t, tx.Decode(NewBinaryTxDecoder(bytes.NewReader(raw))))
+
+	// Trailing bytes inside the record of a block's transaction.
+	b := randomBlockWithSignature(t, 0, types.Hash{})
+	w := util.NewBinaryWriter()
+	w.WriteUint8(CodecVersion)
+	writeHeader(w, b.Header)
+	w.WriteUint32(uint32(len(b.Transactions)))
+	for _, tx := range b.Transactions {
+		txw := util.NewBinaryWriter()
+		writeTx(txw, tx)
+		txw.WriteUint8(0x00)
+		w.WriteBytes(txw.Bytes())
+	}
+	w.WriteBytes(b.Validator)
+	writeSignature(w, b.Signature)
+	assert.NotEmpty(t, b.Transactions)
+	assert.NotNil(t, new(Block).Decode(NewBinaryBlockDecoder(bytes.NewReader(w.Bytes()))))
+}
+
+func TestReceiptHashExcludesError(t *testing.T) {
+	r := &Receipt{
+		TxHash:  types.RandomHash(),
+		Status:  ReceiptStatusFailed,
+		GasUsed: 100,
+		Logs:    []*Log{{Data: []byte("foo")}},
+	}
+	hash := ReceiptHasher{}.Hash(r)
+
+	r.Error = "division by zero"
+	assert.Equal(t, hash, ReceiptHasher{}.Hash(r))
+
+	r.Logs[0].Data = []byte("bar")
+	assert.NotEqual(t, hash, ReceiptHasher{}.Hash(r))
+}
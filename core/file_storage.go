@@ -0,0 +1,354 @@
+package core
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/3ssalunke/go-blockchain/types"
+	"github.com/3ssalunke/go-blockchain/util"
+)
+
+const (
+	blockFileName     = "blocks.dat"
+	receiptFileName   = "receipts.dat"
+	recordHeaderSize  = 8
+	maxBlockRecordLen = 64 << 20
+)
+
+// FileStore is an append-only block store backed by two files, one for
+// blocks and one for their receipts. Every block, and the receipts of every
+// block, is written as a record of [length uint32][crc32 uint32][payload]
+// and the in-memory indexes are rebuilt by scanning the files when they are
+// opened.
+type FileStore struct {
+	lock     sync.RWMutex
+	blocks   *recordFile
+	receipts *recordFile
+	offsets  []int64
+	byHash   map[types.Hash]int64
+	// canonical holds the hash of the canonical block at every height.
+	canonical []types.Hash
+	// receiptsByBlock maps a block hash to the offset of its receipts.
+	receiptsByBlock map[types.Hash]int64
+}
+
+func NewFileStore(dir string) (*FileStore, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+
+	blocks, err := openRecordFile(filepath.Join(dir, blockFileName))
+	if err != nil {
+		return nil, err
+	}
+	receipts, err := openRecordFile(filepath.Join(dir, receiptFileName))
+	if err != nil {
+		blocks.close()
+		return nil, err
+	}
+
+	s := &FileStore{
+		blocks:          blocks,
+		receipts:        receipts,
+		byHash:          make(map[types.Hash]int64),
+		receiptsByBlock: make(map[types.Hash]int64),
+	}
+
+	if err := s.load(); err != nil {
+		s.Close()
+		return nil, err
+	}
+
+	return s, nil
+}
+
+func (s *FileStore) Put(b *Block) error {
+	buf := &bytes.Buffer{}
+	if err := b.Encode(NewBinaryBlockEncoder(buf)); err != nil {
+		return err
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	offset, err := s.blocks.append(buf.Bytes())
+	if err != nil {
+		return err
+	}
+	s.index(b, offset)
+
+	return nil
+}
+
+func (s *FileStore) Get(hash types.Hash) (*Block, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	offset, ok := s.byHash[hash]
+	if !ok {
+		return nil, fmt.Errorf("block not found for hash %s", hash)
+	}
+
+	return s.readBlock(offset)
+}
+
+func (s *FileStore) GetByHeight(height uint32) (*Block, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	if int(height) >= len(s.canonical) {
+		return nil, fmt.Errorf("block not found for height %d", height)
+	}
+
+	return s.readBlock(s.byHash[s.canonical[height]])
+}
+
+func (s *FileStore) SetCanonical(height uint32, hash types.Hash) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if _, ok := s.byHash[hash]; !ok {
+		return fmt.Errorf("block not found for hash %s", hash)
+	}
+	canonical, err := setCanonical(s.canonical, height, hash)
+	if err != nil {
+		return err
+	}
+	s.canonical = canonical
+
+	return nil
+}
+
+func (s *FileStore) Iterate(fn func(*Block) error) error {
+	s.lock.RLock()
+	offsets := make([]int64, len(s.offsets))
+	copy(offsets, s.offsets)
+	s.lock.RUnlock()
+
+	for _, offset := range offsets {
+		s.lock.RLock()
+		b, err := s.readBlock(offset)
+		s.lock.RUnlock()
+		if err != nil {
+			return err
+		}
+
+		if err := fn(b); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (s *FileStore) PutReceipts(blockHash types.Hash, receipts []*Receipt) error {
+	w := util.NewBinaryWriter()
+	w.WriteUint8(CodecVersion)
+	writeReceipts(w, blockHash, receipts)
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if _, ok := s.receiptsByBlock[blockHash]; ok {
+		return nil
+	}
+
+	offset, err := s.receipts.append(w.Bytes())
+	if err != nil {
+		return err
+	}
+	s.receiptsByBlock[blockHash] = offset
+
+	return nil
+}
+
+func (s *FileStore) GetReceipts(blockHash types.Hash) ([]*Receipt, error) {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	offset, ok := s.receiptsByBlock[blockHash]
+	if !ok {
+		return nil, fmt.Errorf("receipts not found for block %s", blockHash)
+	}
+
+	payload, _, err := s.receipts.read(offset)
+	if err != nil {
+		return nil, err
+	}
+	_, receipts, err := decodeReceipts(payload)
+
+	return receipts, err
+}
+
+func (s *FileStore) Close() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	return errors.Join(s.blocks.close(), s.receipts.close())
+}
+
+func (s *FileStore) load() error {
+	err := s.blocks.scan(func(offset int64, payload []byte) error {
+		b, err := decodeBlock(payload)
+		if err != nil {
+			return err
+		}
+		s.index(b, offset)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	return s.receipts.scan(func(offset int64, payload []byte) error {
+		blockHash, _, err := decodeReceipts(payload)
+		if err != nil {
+			return err
+		}
+		s.receiptsByBlock[blockHash] = offset
+		return nil
+	})
+}
+
+func (s *FileStore) index(b *Block, offset int64) {
+	s.offsets = append(s.offsets, offset)
+	s.byHash[b.Hash(BlockHasher{})] = offset
+}
+
+func (s *FileStore) readBlock(offset int64) (*Block, error) {
+	payload, _, err := s.blocks.read(offset)
+	if err != nil {
+		return nil, err
+	}
+	return decodeBlock(payload)
+}
+
+func decodeBlock(payload []byte) (*Block, error) {
+	b := new(Block)
+	if err := b.Decode(NewBinaryBlockDecoder(bytes.NewReader(payload))); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+func decodeReceipts(payload []byte) (types.Hash, []*Receipt, error) {
+	r := util.NewBinaryReader(bytes.NewReader(payload))
+	if err := readCodecVersion(r); err != nil {
+		return types.Hash{}, nil, err
+	}
+	blockHash, receipts := readReceipts(r)
+
+	return blockHash, receipts, r.Err()
+}
+
+// recordFile is an append-only file of checksummed, length-prefixed
+// records.
+type recordFile struct {
+	file *os.File
+	size int64
+}
+
+func openRecordFile(path string) (*recordFile, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, err
+	}
+
+	return &recordFile{
+		file: f,
+	}, nil
+}
+
+// append writes payload as a new record and returns its offset.
+func (f *recordFile) append(payload []byte) (int64, error) {
+	record := make([]byte, recordHeaderSize+len(payload))
+	binary.BigEndian.PutUint32(record[0:4], uint32(len(payload)))
+	binary.BigEndian.PutUint32(record[4:8], crc32.ChecksumIEEE(payload))
+	copy(record[recordHeaderSize:], payload)
+
+	offset := f.size
+	if _, err := f.file.WriteAt(record, offset); err != nil {
+		return 0, err
+	}
+	if err := f.file.Sync(); err != nil {
+		return 0, err
+	}
+	f.size += int64(len(record))
+
+	return offset, nil
+}
+
+// scan calls fn for every record in the file. A record that was only
+// partially written, e.g. because the node crashed in the middle of append,
+// is truncated away; any other inconsistency is reported as corruption.
+func (f *recordFile) scan(fn func(offset int64, payload []byte) error) error {
+	var offset int64
+
+	for {
+		payload, n, err := f.read(offset)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if errors.Is(err, io.ErrUnexpectedEOF) {
+			if err := f.file.Truncate(offset); err != nil {
+				return err
+			}
+			break
+		}
+		if err == nil {
+			err = fn(offset, payload)
+		}
+		if err != nil {
+			return fmt.Errorf("%s corrupted at offset %d: %s", filepath.Base(f.file.Name()), offset, err)
+		}
+
+		offset += n
+	}
+
+	f.size = offset
+
+	return nil
+}
+
+// read returns the payload of the record at offset and the size of the
+// whole record.
+func (f *recordFile) read(offset int64) ([]byte, int64, error) {
+	header := make([]byte, recordHeaderSize)
+	if n, err := f.file.ReadAt(header, offset); err != nil {
+		if errors.Is(err, io.EOF) && n > 0 {
+			return nil, 0, io.ErrUnexpectedEOF
+		}
+		return nil, 0, err
+	}
+
+	length := binary.BigEndian.Uint32(header[0:4])
+	checksum := binary.BigEndian.Uint32(header[4:8])
+	if length > maxBlockRecordLen {
+		return nil, 0, fmt.Errorf("record length %d exceeds maximum", length)
+	}
+
+	payload := make([]byte, length)
+	if _, err := f.file.ReadAt(payload, offset+recordHeaderSize); err != nil {
+		if errors.Is(err, io.EOF) {
+			return nil, 0, io.ErrUnexpectedEOF
+		}
+		return nil, 0, err
+	}
+
+	if crc32.ChecksumIEEE(payload) != checksum {
+		return nil, 0, fmt.Errorf("checksum mismatch")
+	}
+
+	return payload, int64(recordHeaderSize) + int64(length), nil
+}
+
+func (f *recordFile) close() error {
+	return f.file.Close()
+}
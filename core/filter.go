@@ -0,0 +1,125 @@
+package core
+
+import (
+	"fmt"
+
+	"github.com/3ssalunke/go-blockchain/types"
+)
+
+// maxLogQueryRange bounds the number of blocks a single log query scans.
+const maxLogQueryRange = 10_000
+
+// LogFilter selects logs from a range of canonical blocks. A log matches if
+// it was emitted by one of Addresses and carries one of Topics; an empty
+// list matches anything.
+type LogFilter struct {
+	FromHeight uint32
+	ToHeight   uint32
+	Addresses  []types.Address
+	Topics     []types.Hash
+}
+
+// FilteredLog is a log together with where it was emitted.
+type FilteredLog struct {
+	*Log
+	BlockHash types.Hash
+	Height    uint32
+	TxHash    types.Hash
+	// Index is the position of the log among the logs of its block.
+	Index uint32
+}
+
+// GetLogs returns the logs of the canonical chain matching the filter,
+// oldest first. Blocks whose Bloom filter rules out a match are skipped
+// without reading their receipts.
+func (bc *Blockchain) GetLogs(filter LogFilter) ([]*FilteredLog, error) {
+	to := filter.ToHeight
+	if height := bc.Height(); to > height {
+		to = height
+	}
+	if filter.FromHeight > to {
+		return nil, fmt.Errorf("invalid block range %d to %d", filter.FromHeight, to)
+	}
+	if to-filter.FromHeight >= maxLogQueryRange {
+		return nil, fmt.Errorf("block range exceeds maximum of %d blocks", maxLogQueryRange)
+	}
+
+	logs := []*FilteredLog{}
+	for height := filter.FromHeight; height <= to; height++ {
+		b, err := bc.GetBlockByHeight(height)
+		if err != nil {
+			return nil, err
+		}
+		if !filter.mayMatch(&b.LogsBloom) {
+			continue
+		}
+
+		blockHash := b.Hash(BlockHasher{})
+		receipts, err := bc.GetReceipts(blockHash)
+		if err != nil {
+			return nil, err
+		}
+
+		var index uint32
+		for _, r := range receipts {
+			for _, l := range r.Logs {
+				if filter.matches(l) {
+					logs = append(logs, &FilteredLog{
+						Log:       l,
+						BlockHash: blockHash,
+						Height:    height,
+						TxHash:    r.TxHash,
+						Index:     index,
+					})
+				}
+				index++
+			}
+		}
+	}
+
+	return logs, nil
+}
+
+func (f *LogFilter) mayMatch(bloom *Bloom) bool {
+	if *bloom == (Bloom{}) {
+		return false
+	}
+	return anyInBloom(bloom, f.Addresses, func(a types.Address) []byte { return a[:] }) &&
+		anyInBloom(bloom, f.Topics, func(h types.Hash) []byte { return h[:] })
+}
+
+func anyInBloom[T any](bloom *Bloom, values []T, bytesOf func(T) []byte) bool {
+	if len(values) == 0 {
+		return true
+	}
+	for _, v := range values {
+		if bloom.Test(bytesOf(v)) {
+			return true
+		}
+	}
+	return false
+}
+
+func (f *LogFilter) matches(l *Log) bool {
+	if len(f.Addresses) > 0 && !contains(f.Addresses, l.Address) {
+		return false
+	}
+	if len(f.Topics) == 0 {
+		return true
+	}
+	for _, topic := range l.Topics {
+		if contains(f.Topics, topic) {
+			return true
+		}
+	}
+	return false
+}
+
+func contains[T comparable](values []T, v T) bool {
+	for _, value := range values {
+		if value == v {
+			return true
+		}
+	}
+	return false
+}
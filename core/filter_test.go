@@ -0,0 +1,92 @@
+package core
+
+import (
+	"crypto/sha256"
+	"testing"
+
+	"github.com/3ssalunke/go-blockchain/crypto"
+	"github.com/3ssalunke/go-blockchain/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBloom(t *testing.T) {
+	var bloom Bloom
+	assert.False(t, bloom.Test([]byte("foo")))
+
+	bloom.Add([]byte("foo"))
+	assert.True(t, bloom.Test([]byte("foo")))
+	assert.False(t, bloom.Test([]byte("bar")))
+}
+
+// logCode emits a log with the call input as its only topic.
+var logCode = program(pushBytes([]byte("data")), InstrCallData, pushInt(1), InstrLog)
+
+// deployLogContracts deploys n contracts running logCode and returns their
+// addresses.
+func deployLogContracts(t *testing.T, bc *Blockchain, n int) []types.Address {
+	privKey := crypto.GeneratePrivateKey()
+	addrs := make([]types.Address, n)
+	txx := make([]*Transaction, n)
+	for i := range txx {
+		txx[i] = NewDeployTransaction(logCode)
+		txx[i].Nonce = uint64(i)
+		assert.Nil(t, txx[i].Sign(privKey))
+		addrs[i] = ContractAddress(privKey.PublicKey().Address(), uint64(i))
+	}
+	assert.Nil(t, bc.AddBlock(preparedBlock(t, bc, txx...)))
+
+	return addrs
+}
+
+func logTx(t *testing.T, addr types.Address, topic string) *Transaction {
+	tx := NewCallTransaction(addr, []byte(topic))
+	assert.Nil(t, tx.Sign(crypto.GeneratePrivateKey()))
+	return tx
+}
+
+func TestGetLogs(t *testing.T) {
+	bc := newBlockchainWithGenesis(t)
+	addrs := deployLogContracts(t, bc, 2)
+	addrA, addrB := addrs[0], addrs[1]
+
+	b2 := preparedBlock(t, bc, logTx(t, addrA, "transfer"), logTx(t, addrB, "approve"))
+	assert.True(t, b2.LogsBloom.Test(addrA[:]))
+	assert.Nil(t, bc.AddBlock(b2))
+	assert.Nil(t, bc.AddBlock(preparedBlock(t, bc, logTx(t, addrA, "approve"))))
+
+	logs, err := bc.GetLogs(LogFilter{ToHeight: 10})
+	assert.Nil(t, err)
+	assert.Len(t, logs, 3)
+	assert.Equal(t, uint32(1), logs[1].Index)
+	assert.Equal(t, b2.Transactions[1].Hash(TxHasher{}), logs[1].TxHash)
+
+	logs, err = bc.GetLogs(LogFilter{ToHeight: 3, Addresses: []types.Address{addrA}})
+	assert.Nil(t, err)
+	assert.Len(t, logs, 2)
+
+	approve := types.Hash(sha256.Sum256([]byte("approve")))
+	logs, err = bc.GetLogs(LogFilter{FromHeight: 3, ToHeight: 3, Topics: []types.Hash{approve}})
+	assert.Nil(t, err)
+	assert.Len(t, logs, 1)
+	assert.Equal(t, addrA, logs[0].Address)
+	assert.Equal(t, uint32(3), logs[0].Height)
+
+	logs, err = bc.GetLogs(LogFilter{ToHeight: 3, Addresses: []types.Address{addrB}, Topics: []types.Hash{approve}})
+	assert.Nil(t, err)
+	assert.Len(t, logs, 1)
+
+	_, err = bc.GetLogs(LogFilter{FromHeight: 4, ToHeight: 5})
+	assert.NotNil(t, err)
+}
+
+func TestAddBlockInvalidLogsBloom(t *testing.T) {
+	bc := newBlockchainWithGenesis(t)
+
+	addrs := deployLogContracts(t, bc, 1)
+
+	b := preparedBlock(t, bc, logTx(t, addrs[0], "transfer"))
+	b.LogsBloom = Bloom{}
+	assert.Nil(t, b.Sign(crypto.GeneratePrivateKey()))
+	assert.NotNil(t, bc.AddBlock(b))
+	assert.Equal(t, uint32(1), bc.Height())
+}
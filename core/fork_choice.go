@@ -0,0 +1,97 @@
+package core
+
+import "github.com/3ssalunke/go-blockchain/types"
+
+// BlockNode is a block's position in the block tree kept by the Blockchain.
+type BlockNode struct {
+	Hash        types.Hash
+	Block       *Block
+	Parent      *BlockNode
+	Height      uint32
+	TotalWeight uint64
+
+	children []*BlockNode
+}
+
+func newBlockNode(b *Block, parent *BlockNode) *BlockNode {
+	node := &BlockNode{
+		Hash:        b.Hash(BlockHasher{}),
+		Block:       b,
+		Parent:      parent,
+		Height:      b.Height,
+		TotalWeight: BlockWeight(b),
+	}
+	if parent != nil {
+		node.TotalWeight += parent.TotalWeight
+		parent.children = append(parent.children, node)
+	}
+	return node
+}
+
+// Ancestor returns the node at the given height on the branch ending at n,
+// or nil if the height is above n.
+func (n *BlockNode) Ancestor(height uint32) *BlockNode {
+	if height > n.Height {
+		return nil
+	}
+
+	node := n
+	for node != nil && node.Height > height {
+		node = node.Parent
+	}
+	return node
+}
+
+// BlockWeight is one plus the number of transactions in the block, so that
+// between branches of equal length the one carrying more transactions is
+// heavier.
+func BlockWeight(b *Block) uint64 {
+	return 1 + uint64(len(b.Transactions))
+}
+
+type ForkChoice interface {
+	// Better reports whether the branch ending at candidate should replace
+	// the canonical chain ending at head.
+	Better(candidate, head *BlockNode) bool
+}
+
+// LongestChain prefers the branch with the greatest height. On a tie the
+// branch that was seen first is kept.
+type LongestChain struct{}
+
+func (LongestChain) Better(candidate, head *BlockNode) bool {
+	return candidate.Height > head.Height
+}
+
+// HeaviestChain prefers the branch with the greatest total BlockWeight. On a
+// tie the branch that was seen first is kept.
+type HeaviestChain struct{}
+
+func (HeaviestChain) Better(candidate, head *BlockNode) bool {
+	return candidate.TotalWeight > head.TotalWeight
+}
+
+// HighestFinalizedChain treats every block buried at least Depth blocks
+// below the head as final. It prefers the longest branch, but never one that
+// does not contain the head's latest finalized block.
+type HighestFinalizedChain struct {
+	Depth uint32
+}
+
+func (f HighestFinalizedChain) Better(candidate, head *BlockNode) bool {
+	if candidate.Height <= head.Height {
+		return false
+	}
+
+	finalized := f.Finalized(head)
+
+	return candidate.Ancestor(finalized.Height) == finalized
+}
+
+// Finalized returns the latest finalized block on the branch ending at head.
+func (f HighestFinalizedChain) Finalized(head *BlockNode) *BlockNode {
+	if head.Height < f.Depth {
+		return head.Ancestor(0)
+	}
+	return head.Ancestor(head.Height - f.Depth)
+}
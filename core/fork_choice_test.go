@@ -0,0 +1,66 @@
+package core
+
+import (
+	"testing"
+
+	"github.com/3ssalunke/go-blockchain/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func buildBranch(t *testing.T, root *BlockNode, length int, txsPerBlock int) *BlockNode {
+	node := root
+	for i := 0; i < length; i++ {
+		b, err := randomBlock(node.Height+1, node.Hash)
+		assert.Nil(t, err)
+		for j := 0; j < txsPerBlock; j++ {
+			b.AddNewTransaction(randomTxWithSignature(t))
+		}
+		node = newBlockNode(b, node)
+	}
+	return node
+}
+
+func TestLongestChain(t *testing.T) {
+	genesis, err := randomBlock(0, types.Hash{})
+	assert.Nil(t, err)
+	root := newBlockNode(genesis, nil)
+
+	short := buildBranch(t, root, 2, 5)
+	long := buildBranch(t, root, 3, 0)
+
+	assert.True(t, LongestChain{}.Better(long, short))
+	assert.False(t, LongestChain{}.Better(short, long))
+	assert.False(t, LongestChain{}.Better(buildBranch(t, root, 2, 0), short))
+}
+
+func TestHeaviestChain(t *testing.T) {
+	genesis, err := randomBlock(0, types.Hash{})
+	assert.Nil(t, err)
+	root := newBlockNode(genesis, nil)
+
+	heavy := buildBranch(t, root, 2, 5)
+	long := buildBranch(t, root, 3, 0)
+
+	assert.Equal(t, uint64(1+6+6), heavy.TotalWeight)
+	assert.True(t, HeaviestChain{}.Better(heavy, long))
+	assert.False(t, HeaviestChain{}.Better(long, heavy))
+}
+
+func TestHighestFinalizedChain(t *testing.T) {
+	genesis, err := randomBlock(0, types.Hash{})
+	assert.Nil(t, err)
+	root := newBlockNode(genesis, nil)
+	fc := HighestFinalizedChain{Depth: 2}
+
+	head := buildBranch(t, root, 4, 0)
+	assert.Equal(t, uint32(2), fc.Finalized(head).Height)
+
+	// Forking off below the finalized block is never preferred.
+	deepFork := buildBranch(t, root, 6, 0)
+	assert.False(t, fc.Better(deepFork, head))
+
+	// Forking off above it is, once the fork is longer.
+	shallowFork := buildBranch(t, head.Ancestor(2), 3, 0)
+	assert.True(t, fc.Better(shallowFork, head))
+	assert.False(t, fc.Better(buildBranch(t, head.Ancestor(2), 2, 0), head))
+}
@@ -2,7 +2,6 @@ package core
 
 import (
 	"crypto/sha256"
-	"encoding/binary"
 
 	"github.com/3ssalunke/go-blockchain/types"
 )
@@ -20,12 +19,17 @@ func (BlockHasher) Hash(hd *Header) types.Hash {
 
 type TxHasher struct{}
 
+// Hash commits to the transaction's signing payload, which includes the
+// sender, but not to the signature itself, so re-encoding a signature does
+// not change the transaction's identity.
 func (TxHasher) Hash(tx *Transaction) types.Hash {
-	buf := make([]byte, 8)
-	binary.LittleEndian.PutUint64(buf, uint64(tx.Nonce))
+	h := sha256.Sum256(tx.SigningPayload())
+	return types.Hash(h)
+}
 
-	data := append(buf, tx.Data...)
+type ReceiptHasher struct{}
 
-	h := sha256.Sum256(data)
+func (ReceiptHasher) Hash(r *Receipt) types.Hash {
+	h := sha256.Sum256(r.Bytes())
 	return types.Hash(h)
 }
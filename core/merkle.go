@@ -0,0 +1,140 @@
+package core
+
+import (
+	"crypto/sha256"
+	"fmt"
+
+	"github.com/3ssalunke/go-blockchain/types"
+)
+
+// The Merkle tree follows RFC 6962: leaves and interior nodes are hashed
+// with distinct prefixes so a leaf can never be passed off as a node, and a
+// tree of n leaves is split at the largest power of two below n instead of
+// duplicating the last leaf. The root of an empty tree is the zero hash.
+const (
+	merkleLeafPrefix = 0x00
+	merkleNodePrefix = 0x01
+)
+
+type MerkleProof struct {
+	TxHash  types.Hash
+	Index   uint32
+	TxCount uint32
+	// Path holds the sibling hashes from the leaf up to the root.
+	Path []types.Hash
+}
+
+func MerkleRoot(leaves []types.Hash) types.Hash {
+	if len(leaves) == 0 {
+		return types.Hash{}
+	}
+	return merkleTreeHash(leaves)
+}
+
+// Verify checks that the proof links its transaction to the header's
+// DataHash.
+func (p *MerkleProof) Verify(h *Header) error {
+	if p.Index >= p.TxCount {
+		return fmt.Errorf("proof index %d out of range for %d transactions", p.Index, p.TxCount)
+	}
+
+	var (
+		fn   = p.Index
+		sn   = p.TxCount - 1
+		hash = merkleLeafHash(p.TxHash)
+	)
+
+	for _, sibling := range p.Path {
+		if sn == 0 {
+			return fmt.Errorf("proof path is too long")
+		}
+		if fn&1 == 1 || fn == sn {
+			hash = merkleNodeHash(sibling, hash)
+			for fn&1 == 0 && fn != 0 {
+				fn >>= 1
+				sn >>= 1
+			}
+		} else {
+			hash = merkleNodeHash(hash, sibling)
+		}
+		fn >>= 1
+		sn >>= 1
+	}
+
+	if sn != 0 {
+		return fmt.Errorf("proof path is too short")
+	}
+	if hash != h.DataHash {
+		return fmt.Errorf("transaction (%s) is not included in block with data hash (%s)", p.TxHash, h.DataHash)
+	}
+
+	return nil
+}
+
+// NewMerkleProof builds the inclusion proof of the transaction with the given
+// hash among txx.
+func NewMerkleProof(txx []*Transaction, txHash types.Hash) (*MerkleProof, error) {
+	leaves := make([]types.Hash, len(txx))
+	index := -1
+	for i, tx := range txx {
+		leaves[i] = tx.Hash(TxHasher{})
+		if leaves[i] == txHash {
+			index = i
+		}
+	}
+	if index == -1 {
+		return nil, fmt.Errorf("transaction (%s) not found", txHash)
+	}
+
+	return &MerkleProof{
+		TxHash:  txHash,
+		Index:   uint32(index),
+		TxCount: uint32(len(leaves)),
+		Path:    merklePath(index, leaves),
+	}, nil
+}
+
+func merklePath(index int, leaves []types.Hash) []types.Hash {
+	if len(leaves) <= 1 {
+		return []types.Hash{}
+	}
+
+	k := merkleSplit(len(leaves))
+	if index < k {
+		return append(merklePath(index, leaves[:k]), merkleTreeHash(leaves[k:]))
+	}
+	return append(merklePath(index-k, leaves[k:]), merkleTreeHash(leaves[:k]))
+}
+
+func merkleTreeHash(leaves []types.Hash) types.Hash {
+	if len(leaves) == 1 {
+		return merkleLeafHash(leaves[0])
+	}
+
+	k := merkleSplit(len(leaves))
+	return merkleNodeHash(merkleTreeHash(leaves[:k]), merkleTreeHash(leaves[k:]))
+}
+
+// merkleSplit returns the largest power of two smaller than n.
+func merkleSplit(n int) int {
+	k := 1
+	for k<<1 < n {
+		k <<= 1
+	}
+	return k
+}
+
+func merkleLeafHash(leaf types.Hash) types.Hash {
+	buf := make([]byte, 0, 1+len(leaf))
+	buf = append(buf, merkleLeafPrefix)
+	buf = append(buf, leaf[:]...)
+	return sha256.Sum256(buf)
+}
+
+func merkleNodeHash(left, right types.Hash) types.Hash {
+	buf := make([]byte, 0, 1+len(left)+len(right))
+	buf = append(buf, merkleNodePrefix)
+	buf = append(buf, left[:]...)
+	buf = append(buf, right[:]...)
+	return sha256.Sum256(buf)
+}
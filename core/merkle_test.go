@@ -0,0 +1,72 @@
+package core
+
+import (
+	"testing"
+
+	"github.com/3ssalunke/go-blockchain/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMerkleRoot(t *testing.T) {
+	assert.True(t, MerkleRoot(nil).IsZero())
+
+	a, b, c := types.RandomHash(), types.RandomHash(), types.RandomHash()
+
+	assert.Equal(t, merkleLeafHash(a), MerkleRoot([]types.Hash{a}))
+	assert.Equal(t, merkleNodeHash(merkleLeafHash(a), merkleLeafHash(b)), MerkleRoot([]types.Hash{a, b}))
+	assert.Equal(t,
+		merkleNodeHash(merkleNodeHash(merkleLeafHash(a), merkleLeafHash(b)), merkleLeafHash(c)),
+		MerkleRoot([]types.Hash{a, b, c}),
+	)
+	assert.NotEqual(t, MerkleRoot([]types.Hash{a, b}), MerkleRoot([]types.Hash{b, a}))
+}
+
+func TestMerkleProof(t *testing.T) {
+	for n := 1; n <= 9; n++ {
+		txx := make([]*Transaction, n)
+		for i := range txx {
+			txx[i] = NewTransaction(types.RandomBytes(8))
+		}
+		b, err := NewBlockFromPrevHeader(&Header{}, txx)
+		assert.Nil(t, err)
+
+		for i, tx := range txx {
+			proof, err := b.MerkleProof(tx.Hash(TxHasher{}))
+			assert.Nil(t, err)
+			assert.Equal(t, uint32(i), proof.Index)
+			assert.Nil(t, proof.Verify(b.Header), "n=%d i=%d", n, i)
+		}
+	}
+}
+
+func TestMerkleProofRejectsTampering(t *testing.T) {
+	txx := []*Transaction{
+		NewTransaction(types.RandomBytes(8)),
+		NewTransaction(types.RandomBytes(8)),
+		NewTransaction(types.RandomBytes(8)),
+	}
+	b, err := NewBlockFromPrevHeader(&Header{}, txx)
+	assert.Nil(t, err)
+
+	proof, err := b.MerkleProof(txx[1].Hash(TxHasher{}))
+	assert.Nil(t, err)
+
+	_, err = b.MerkleProof(types.RandomHash())
+	assert.NotNil(t, err)
+
+	forged := *proof
+	forged.TxHash = types.RandomHash()
+	assert.NotNil(t, forged.Verify(b.Header))
+
+	forged = *proof
+	forged.Index = 0
+	assert.NotNil(t, forged.Verify(b.Header))
+
+	forged = *proof
+	forged.Path = forged.Path[:1]
+	assert.NotNil(t, forged.Verify(b.Header))
+
+	other, err := NewBlockFromPrevHeader(&Header{}, txx[:2])
+	assert.Nil(t, err)
+	assert.NotNil(t, proof.Verify(other.Header))
+}
@@ -0,0 +1,108 @@
+package core
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"math/big"
+
+	"github.com/3ssalunke/go-blockchain/crypto"
+	"github.com/3ssalunke/go-blockchain/types"
+)
+
+// Addresses of the precompiled contracts. Calling one of them runs native
+// code instead of bytecode: the call input is the argument and the return
+// value the result.
+var (
+	// PrecompileSha256 returns the sha256 hash of its input.
+	PrecompileSha256 = precompileAddress(1)
+	// PrecompileVerify checks a signature. Its input is a compressed
+	// public key of 33 bytes, the R and S of the signature as 32 big endian
+	// bytes each and then the signed data. It returns the integer 1 if the
+	// signature is valid and 0 otherwise.
+	PrecompileVerify = precompileAddress(2)
+	// PrecompilePubKeyAddress returns the address of the compressed public
+	// key it is given.
+	PrecompilePubKeyAddress = precompileAddress(3)
+)
+
+// Gas charged by the precompiled contracts. Hashing is also charged per 32
+// byte word of hashed data.
+const (
+	GasSha256        uint64 = 60
+	GasSha256Word    uint64 = 12
+	GasVerify        uint64 = 3000
+	GasPubKeyAddress uint64 = 100
+)
+
+const (
+	pubKeyLength = 33
+	sigLength    = 64
+)
+
+// precompile is a contract implemented in Go.
+type precompile interface {
+	// Gas returns the cost of running the contract on input.
+	Gas(input []byte) uint64
+	Run(input []byte) ([]byte, error)
+}
+
+var precompiles = map[types.Address]precompile{
+	PrecompileSha256:        sha256Precompile{},
+	PrecompileVerify:        verifyPrecompile{},
+	PrecompilePubKeyAddress: pubKeyAddressPrecompile{},
+}
+
+func precompileAddress(n byte) types.Address {
+	var addr types.Address
+	addr[len(addr)-1] = n
+	return addr
+}
+
+func hashGas(data []byte) uint64 {
+	return (uint64(len(data)) + 31) / 32 * GasSha256Word
+}
+
+type sha256Precompile struct{}
+
+func (sha256Precompile) Gas(input []byte) uint64 {
+	return GasSha256 + hashGas(input)
+}
+
+func (sha256Precompile) Run(input []byte) ([]byte, error) {
+	h := sha256.Sum256(input)
+	return h[:], nil
+}
+
+type verifyPrecompile struct{}
+
+func (verifyPrecompile) Gas(input []byte) uint64 {
+	return GasVerify + hashGas(input)
+}
+
+func (verifyPrecompile) Run(input []byte) ([]byte, error) {
+	if len(input) < pubKeyLength+sigLength {
+		return nil, fmt.Errorf("%w: verify input has length %d, expected at least %d", ErrInvalidOperand, len(input), pubKeyLength+sigLength)
+	}
+
+	pubKey := crypto.PublicKey(input[:pubKeyLength])
+	sig := crypto.Signature{
+		R: new(big.Int).SetBytes(input[pubKeyLength : pubKeyLength+32]),
+		S: new(big.Int).SetBytes(input[pubKeyLength+32 : pubKeyLength+sigLength]),
+	}
+	valid := sig.Verify(pubKey, input[pubKeyLength+sigLength:])
+
+	return IntValue(boolToInt(valid)).Serialize(), nil
+}
+
+type pubKeyAddressPrecompile struct{}
+
+func (pubKeyAddressPrecompile) Gas(input []byte) uint64 {
+	return GasPubKeyAddress
+}
+
+func (pubKeyAddressPrecompile) Run(input []byte) ([]byte, error) {
+	if len(input) != pubKeyLength {
+		return nil, fmt.Errorf("%w: public key has length %d, expected %d", ErrInvalidOperand, len(input), pubKeyLength)
+	}
+	return crypto.PublicKey(input).Address().ToSlice(), nil
+}
@@ -0,0 +1,94 @@
+package core
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+
+	"github.com/3ssalunke/go-blockchain/crypto"
+	"github.com/3ssalunke/go-blockchain/types"
+	"github.com/stretchr/testify/assert"
+)
+
+// A P-256 key, its address and its signature of verifyData.
+var (
+	verifyPubKey = decodeHex("03b295bc589076b5661124939dc4b68167d645ad78f8941ed70c81d40ae549239a")
+	verifyAddr   = decodeHex("c351f9cf515f2483b3c129961bb33661c4d06ffb")
+	verifySig    = decodeHex("3a334bb4ca2b05283d8ab5047cd121321536745fedc55a904198344ecf4422fc" +
+		"06233b668a26cec4e275105ac35156944f5af5509c10466a9d5a4e43b5a6a11f")
+	verifyData = []byte("transfer 10 to bob")
+)
+
+func decodeHex(s string) []byte {
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		panic(err)
+	}
+	return b
+}
+
+func verifyInput(pubKey, sig, data []byte) []byte {
+	return bytes.Join([][]byte{pubKey, sig, data}, nil)
+}
+
+func TestPrecompiles(t *testing.T) {
+	invalidKey := bytes.Repeat([]byte{0x02}, pubKeyLength)
+	otherKey := crypto.GeneratePrivateKey().PublicKey()
+
+	tests := []struct {
+		name  string
+		addr  types.Address
+		input []byte
+		want  []byte
+	}{
+		{"sha256", PrecompileSha256, []byte("abc"), decodeHex("ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad")},
+		{"sha256 empty", PrecompileSha256, nil, decodeHex("e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855")},
+		{"verify", PrecompileVerify, verifyInput(verifyPubKey, verifySig, verifyData), IntValue(1).Serialize()},
+		{"verify other data", PrecompileVerify, verifyInput(verifyPubKey, verifySig, []byte("transfer 99 to bob")), IntValue(0).Serialize()},
+		{"verify other key", PrecompileVerify, verifyInput(otherKey, verifySig, verifyData), IntValue(0).Serialize()},
+		{"verify invalid key", PrecompileVerify, verifyInput(invalidKey, verifySig, verifyData), IntValue(0).Serialize()},
+		{"pubkey address", PrecompilePubKeyAddress, verifyPubKey, verifyAddr},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			vm := NewVM(callProgram(InstrStaticCall, tt.addr, tt.input), NewState(), 100_000)
+			assert.Nil(t, vm.Run())
+			assert.Equal(t, 1, top(t, vm))
+			assert.Equal(t, tt.want, top(t, vm))
+		})
+	}
+
+	assert.Equal(t, crypto.PublicKey(verifyPubKey).Address().ToSlice(), verifyAddr)
+}
+
+func TestPrecompileGas(t *testing.T) {
+	// The gas of the calling program itself, which pushes the input and
+	// the address as byte strings.
+	input := make([]byte, 33)
+	callGas := 2*GasQuick + GasMid + 2*GasFast + GasCall + uint64(len(input)+len(PrecompileSha256))*GasCopyByte
+
+	vm := NewVM(callProgram(InstrStaticCall, PrecompileSha256, input), NewState(), 100_000)
+	assert.Nil(t, vm.Run())
+	assert.Equal(t, callGas+GasSha256+2*GasSha256Word, vm.GasUsed())
+
+	// A precompile that cannot be paid for uses up the allowance.
+	code := program(pushInt(10), pushBytes(verifyPubKey), pushBytes(PrecompilePubKeyAddress[:]), InstrStaticCall)
+	vm = NewVM(code, NewState(), 100_000)
+	assert.Nil(t, vm.Run())
+	assert.Equal(t, 0, top(t, vm))
+	pushGas := uint64(len(verifyPubKey)+len(PrecompilePubKeyAddress)) * GasCopyByte
+	assert.Equal(t, GasQuick+2*GasFast+pushGas+GasCall+10, vm.GasUsed())
+}
+
+func TestPrecompileInvalidInput(t *testing.T) {
+	for addr, input := range map[types.Address][]byte{
+		PrecompileVerify:        verifyPubKey,
+		PrecompilePubKeyAddress: verifyPubKey[1:],
+	} {
+		vm := NewVM(callProgram(InstrStaticCall, addr, input), NewState(), 100_000)
+		assert.Nil(t, vm.Run())
+		assert.Equal(t, 0, top(t, vm))
+		assert.Equal(t, []byte{}, top(t, vm))
+	}
+}
@@ -0,0 +1,75 @@
+package core
+
+import (
+	"github.com/3ssalunke/go-blockchain/types"
+	"github.com/3ssalunke/go-blockchain/util"
+)
+
+type ReceiptStatus uint8
+
+const (
+	// ReceiptStatusFailed marks a transaction that was included in a block
+	// but whose execution faulted, so none of its writes were kept.
+	ReceiptStatusFailed ReceiptStatus = iota
+	ReceiptStatusSuccess
+)
+
+func (s ReceiptStatus) String() string {
+	if s == ReceiptStatusSuccess {
+		return "success"
+	}
+	return "failed"
+}
+
+// Log is a piece of data emitted by a transaction while it executes.
+type Log struct {
+	// Address is the address whose code emitted the log.
+	Address types.Address
+	Topics  []types.Hash
+	Data    []byte
+}
+
+// Receipt records the outcome of executing a transaction in a block.
+type Receipt struct {
+	TxHash  types.Hash
+	Status  ReceiptStatus
+	GasUsed uint64
+	Logs    []*Log
+	// Error describes why execution failed. It is empty on success and, as
+	// its wording is not part of consensus, not covered by the receipt hash.
+	Error string
+}
+
+func newReceipt(tx *Transaction, gasUsed uint64, logs []*Log, err error) *Receipt {
+	r := &Receipt{
+		TxHash:  tx.Hash(TxHasher{}),
+		Status:  ReceiptStatusSuccess,
+		GasUsed: gasUsed,
+		Logs:    logs,
+	}
+	if err != nil {
+		r.Status = ReceiptStatusFailed
+		r.Error = err.Error()
+	}
+	return r
+}
+
+// Bytes returns the canonical encoding of the consensus fields of the
+// receipt, which is what ReceiptHasher hashes.
+func (r *Receipt) Bytes() []byte {
+	w := util.NewBinaryWriter()
+	w.WriteUint8(CodecVersion)
+	writeReceiptFields(w, r)
+
+	return w.Bytes()
+}
+
+// CalculateReceiptsRoot returns the Merkle root over the hashes of receipts.
+func CalculateReceiptsRoot(receipts []*Receipt) types.Hash {
+	leaves := make([]types.Hash, len(receipts))
+	for i, r := range receipts {
+		leaves[i] = ReceiptHasher{}.Hash(r)
+	}
+
+	return MerkleRoot(leaves)
+}
@@ -0,0 +1,112 @@
+package core
+
+import (
+	"fmt"
+
+	"github.com/3ssalunke/go-blockchain/util"
+)
+
+// Value is a VM stack value: either an integer or a byte string.
+type Value struct {
+	bytes   []byte
+	n       int64
+	isBytes bool
+}
+
+func IntValue(n int64) Value {
+	return Value{n: n}
+}
+
+func BytesValue(b []byte) Value {
+	return Value{bytes: b, isBytes: true}
+}
+
+// Int returns the value as an integer, reporting false if it is bytes.
+func (v Value) Int() (int64, bool) {
+	return v.n, !v.isBytes
+}
+
+// Bytes returns the value as bytes, reporting false if it is an integer.
+func (v Value) Bytes() ([]byte, bool) {
+	return v.bytes, v.isBytes
+}
+
+// size returns the number of bytes the value holds.
+func (v Value) size() int {
+	if v.isBytes {
+		return len(v.bytes)
+	}
+	return 8
+}
+
+// Serialize returns the form in which the value is written to the contract
+// state and compared: bytes as they are and integers as 8 little endian
+// bytes.
+func (v Value) Serialize() []byte {
+	if v.isBytes {
+		return v.bytes
+	}
+	return util.SerializeInt64(v.n)
+}
+
+func (v Value) String() string {
+	if v.isBytes {
+		return fmt.Sprintf("0x%x", v.bytes)
+	}
+	return fmt.Sprint(v.n)
+}
+
+// Stack is the VM's operand stack. It holds at most maxStackDepth values in
+// a fixed array, so pushing and popping never allocate.
+type Stack struct {
+	data [maxStackDepth]Value
+	sp   int
+}
+
+func NewStack() *Stack {
+	return &Stack{}
+}
+
+// Push adds v on top of the stack. Byte strings longer than maxValueSize are
+// refused.
+func (s *Stack) Push(v Value) error {
+	if s.sp == len(s.data) {
+		return ErrStackOverflow
+	}
+	if v.size() > maxValueSize {
+		return ErrValueSize
+	}
+	s.data[s.sp] = v
+	s.sp++
+	return nil
+}
+
+func (s *Stack) Pop() (Value, error) {
+	if s.sp == 0 {
+		return Value{}, ErrStackUnderflow
+	}
+	s.sp--
+	v := s.data[s.sp]
+	// Drop the reference so popped bytes can be collected.
+	s.data[s.sp] = Value{}
+	return v, nil
+}
+
+// Peek returns the value on top of the stack without removing it.
+func (s *Stack) Peek() (Value, error) {
+	if s.sp == 0 {
+		return Value{}, ErrStackUnderflow
+	}
+	return s.data[s.sp-1], nil
+}
+
+func (s *Stack) Len() int {
+	return s.sp
+}
+
+// Values returns a copy of the values on the stack, bottom first.
+func (s *Stack) Values() []Value {
+	values := make([]Value, s.sp)
+	copy(values, s.data[:s.sp])
+	return values
+}
@@ -0,0 +1,55 @@
+package core
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStack(t *testing.T) {
+	s := NewStack()
+
+	_, err := s.Pop()
+	assert.ErrorIs(t, err, ErrStackUnderflow)
+
+	for i := 0; i < maxStackDepth; i++ {
+		assert.Nil(t, s.Push(IntValue(int64(i))))
+	}
+	assert.ErrorIs(t, s.Push(IntValue(0)), ErrStackOverflow)
+	assert.Equal(t, maxStackDepth, s.Len())
+
+	v, err := s.Pop()
+	assert.Nil(t, err)
+	n, ok := v.Int()
+	assert.True(t, ok)
+	assert.Equal(t, int64(maxStackDepth-1), n)
+
+	assert.Nil(t, s.Push(BytesValue([]byte("abc"))))
+	v, err = s.Pop()
+	assert.Nil(t, err)
+	_, ok = v.Int()
+	assert.False(t, ok)
+	b, ok := v.Bytes()
+	assert.True(t, ok)
+	assert.Equal(t, []byte("abc"), b)
+
+	assert.ErrorIs(t, s.Push(BytesValue(make([]byte, maxValueSize+1))), ErrValueSize)
+	assert.Nil(t, s.Push(BytesValue(make([]byte, maxValueSize))))
+}
+
+func TestValueSerialize(t *testing.T) {
+	assert.Equal(t, []byte{7, 0, 0, 0, 0, 0, 0, 0}, IntValue(7).Serialize())
+	assert.Equal(t, []byte("abc"), BytesValue([]byte("abc")).Serialize())
+	assert.Equal(t, "7", IntValue(7).String())
+	assert.Equal(t, "0x616263", BytesValue([]byte("abc")).String())
+}
+
+func BenchmarkStackPushPop(b *testing.B) {
+	s := NewStack()
+	b.ReportAllocs()
+
+	for i := 0; i < b.N; i++ {
+		_ = s.Push(IntValue(int64(i)))
+		_, _ = s.Pop()
+	}
+}
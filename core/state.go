@@ -1,9 +1,40 @@
 package core
 
-import "fmt"
+import (
+	"bytes"
+	"fmt"
+)
 
+// stateChange records the value a key held before it was written so the
+// write can be undone.
+type stateChange struct {
+	key     string
+	prev    []byte
+	existed bool
+}
+
+// Journal is a list of changes made to a State, oldest first.
+type Journal []stateChange
+
+// StateDiff is the change made to a single key. Before and After are nil
+// when the key did not exist.
+type StateDiff struct {
+	Key    []byte
+	Before []byte
+	After  []byte
+}
+
+// State is the key value store all accounts and contracts live in. Every
+// write is recorded in a journal so that a failed transaction or a rejected
+// block can be undone.
 type State struct {
-	data map[string][]byte
+	data    map[string][]byte
+	journal Journal
+
+	// tree is the state tree as of the last call to Root and dirty the keys
+	// written since. The tree is built on the first call to Root.
+	tree  *stateNode
+	dirty map[string]struct{}
 }
 
 func NewState() *State {
@@ -12,12 +43,24 @@ func NewState() *State {
 	}
 }
 
+// Copy returns a state holding the same data as s, with an empty journal.
+// Values are shared, which is safe since they are never modified in place.
+func (s *State) Copy() *State {
+	data := make(map[string][]byte, len(s.data))
+	for k, v := range s.data {
+		data[k] = v
+	}
+	return &State{data: data}
+}
+
 func (s *State) Put(k, v []byte) error {
+	s.record(string(k))
 	s.data[string(k)] = v
 	return nil
 }
 
 func (s *State) Delete(k []byte) error {
+	s.record(string(k))
 	delete(s.data, string(k))
 	return nil
 }
@@ -30,3 +73,107 @@ func (s *State) Get(k []byte) ([]byte, error) {
 	}
 	return value, nil
 }
+
+func (s *State) record(key string) {
+	s.markDirty(key)
+	prev, existed := s.data[key]
+	s.journal = append(s.journal, stateChange{
+		key:     key,
+		prev:    prev,
+		existed: existed,
+	})
+}
+
+// Snapshot returns an identifier for the current state, to be passed to
+// RevertToSnapshot. Snapshots can be nested but are only valid until the
+// next Commit.
+func (s *State) Snapshot() int {
+	return len(s.journal)
+}
+
+// RevertToSnapshot undoes every change made since the snapshot was taken.
+func (s *State) RevertToSnapshot(id int) {
+	if id < 0 || id > len(s.journal) {
+		panic(fmt.Sprintf("state: invalid snapshot %d", id))
+	}
+	s.Revert(s.journal[id:])
+	s.journal = s.journal[:id]
+}
+
+// Commit ends the current journal and returns it. The changes can then no
+// longer be reverted with RevertToSnapshot, but the returned journal can
+// still undo them with Revert, e.g. when a block is rolled back in a
+// reorg.
+func (s *State) Commit() Journal {
+	changes := s.journal
+	s.journal = nil
+	return changes
+}
+
+// Revert undoes the changes of a journal, newest first, without recording
+// them.
+func (s *State) Revert(changes Journal) {
+	for i := len(changes) - 1; i >= 0; i-- {
+		c := changes[i]
+		s.markDirty(c.key)
+		if c.existed {
+			s.data[c.key] = c.prev
+		} else {
+			delete(s.data, c.key)
+		}
+	}
+}
+
+// redo returns a journal that reapplies changes once they have been undone
+// with Revert. It must be taken before reverting them.
+func (s *State) redo(changes Journal) Journal {
+	redo := make(Journal, len(changes))
+	for i, c := range changes {
+		value, exists := s.data[c.key]
+		redo[i] = stateChange{
+			key:     c.key,
+			prev:    value,
+			existed: exists,
+		}
+	}
+	return redo
+}
+
+// Diff returns the keys changed since the snapshot was taken, in the order
+// they were first changed. Keys that ended up with their previous value are
+// left out.
+func (s *State) Diff(snapshot int) []StateDiff {
+	seen := map[string]bool{}
+	diff := []StateDiff{}
+
+	for _, c := range s.journal[snapshot:] {
+		if seen[c.key] {
+			continue
+		}
+		seen[c.key] = true
+
+		after, exists := s.data[c.key]
+		if exists == c.existed && bytes.Equal(after, c.prev) {
+			continue
+		}
+		diff = append(diff, StateDiff{
+			Key:    []byte(c.key),
+			Before: presentValue(c.prev, c.existed),
+			After:  presentValue(after, exists),
+		})
+	}
+
+	return diff
+}
+
+// presentValue returns v, or nil if the key does not exist, making sure an
+// existing empty value is not nil.
+func presentValue(v []byte, exists bool) []byte {
+	if !exists {
+		return nil
+	}
+	if v == nil {
+		return []byte{}
+	}
+	return v
+}
@@ -0,0 +1,179 @@
+package core
+
+import (
+	"crypto/sha256"
+
+	"github.com/3ssalunke/go-blockchain/types"
+)
+
+// The state root is the root of a sparse Merkle tree keyed by sha256(key).
+// Empty subtrees hash to the zero hash and a subtree holding a single entry
+// is replaced by that entry's leaf hash, so the root only costs hashing
+// along the paths that actually distinguish the keys.
+//
+// The tree is kept between calls to Root and only the keys written since
+// the previous call are updated, along with the hashes on their paths.
+const (
+	stateLeafPrefix = 0x00
+	stateNodePrefix = 0x01
+	stateTreeDepth  = 256
+)
+
+// stateNode is a node of the state tree. A leaf holds a single entry; an
+// inner node always has at least two entries below it.
+type stateNode struct {
+	leaf        bool
+	path        types.Hash
+	hash        types.Hash
+	left, right *stateNode
+	// dirty is set on inner nodes whose hash must be recomputed.
+	dirty bool
+}
+
+// Root returns the state root committing to every key/value pair in s.
+func (s *State) Root() types.Hash {
+	if s.tree == nil {
+		for k, v := range s.data {
+			s.tree = stateInsert(s.tree, 0, newStateLeaf(k, v))
+		}
+	} else {
+		for k := range s.dirty {
+			if v, ok := s.data[k]; ok {
+				s.tree = stateInsert(s.tree, 0, newStateLeaf(k, v))
+			} else {
+				s.tree = stateRemove(s.tree, 0, sha256.Sum256([]byte(k)))
+			}
+		}
+	}
+	s.dirty = make(map[string]struct{})
+
+	return stateHash(s.tree)
+}
+
+// markDirty records that key must be updated in the state tree.
+func (s *State) markDirty(key string) {
+	if s.tree != nil {
+		s.dirty[key] = struct{}{}
+	}
+}
+
+func newStateLeaf(key string, value []byte) *stateNode {
+	path := sha256.Sum256([]byte(key))
+	return &stateNode{
+		leaf: true,
+		path: path,
+		hash: stateLeafHash(path, value),
+	}
+}
+
+// stateInsert adds leaf to the subtree n at depth, replacing the entry with
+// the same path, and returns the new subtree.
+func stateInsert(n *stateNode, depth int, leaf *stateNode) *stateNode {
+	switch {
+	case n == nil:
+		return leaf
+	case n.leaf && n.path == leaf.path:
+		return leaf
+	case n.leaf:
+		return stateSplit(n, leaf, depth)
+	}
+
+	if pathBit(leaf.path, depth) == 0 {
+		n.left = stateInsert(n.left, depth+1, leaf)
+	} else {
+		n.right = stateInsert(n.right, depth+1, leaf)
+	}
+	n.dirty = true
+
+	return n
+}
+
+// stateSplit returns the subtree at depth holding the two leaves.
+func stateSplit(a, b *stateNode, depth int) *stateNode {
+	if depth == stateTreeDepth {
+		// Only reachable with colliding sha256 paths.
+		panic("state tree paths collide")
+	}
+
+	n := &stateNode{dirty: true}
+	abit, bbit := pathBit(a.path, depth), pathBit(b.path, depth)
+	switch {
+	case abit != bbit && abit == 0:
+		n.left, n.right = a, b
+	case abit != bbit:
+		n.left, n.right = b, a
+	case abit == 0:
+		n.left = stateSplit(a, b, depth+1)
+	default:
+		n.right = stateSplit(a, b, depth+1)
+	}
+
+	return n
+}
+
+// stateRemove removes the entry with the given path from the subtree n at
+// depth and returns the new subtree. A subtree left with a single entry is
+// replaced by that entry's leaf.
+func stateRemove(n *stateNode, depth int, path types.Hash) *stateNode {
+	switch {
+	case n == nil:
+		return nil
+	case n.leaf && n.path == path:
+		return nil
+	case n.leaf:
+		return n
+	}
+
+	if pathBit(path, depth) == 0 {
+		n.left = stateRemove(n.left, depth+1, path)
+	} else {
+		n.right = stateRemove(n.right, depth+1, path)
+	}
+	n.dirty = true
+
+	switch {
+	case n.left == nil && n.right == nil:
+		return nil
+	case n.left == nil && n.right.leaf:
+		return n.right
+	case n.right == nil && n.left.leaf:
+		return n.left
+	}
+
+	return n
+}
+
+func stateHash(n *stateNode) types.Hash {
+	if n == nil {
+		return types.Hash{}
+	}
+	if n.leaf || !n.dirty {
+		return n.hash
+	}
+
+	left := stateHash(n.left)
+	right := stateHash(n.right)
+
+	buf := make([]byte, 0, 1+2*len(left))
+	buf = append(buf, stateNodePrefix)
+	buf = append(buf, left[:]...)
+	buf = append(buf, right[:]...)
+	n.hash = sha256.Sum256(buf)
+	n.dirty = false
+
+	return n.hash
+}
+
+func stateLeafHash(path types.Hash, value []byte) types.Hash {
+	valueHash := sha256.Sum256(value)
+
+	buf := make([]byte, 0, 1+len(path)+len(valueHash))
+	buf = append(buf, stateLeafPrefix)
+	buf = append(buf, path[:]...)
+	buf = append(buf, valueHash[:]...)
+	return sha256.Sum256(buf)
+}
+
+func pathBit(path types.Hash, depth int) byte {
+	return (path[depth/8] >> (7 - depth%8)) & 1
+}
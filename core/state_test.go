@@ -0,0 +1,170 @@
+package core
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"math/rand"
+	"sort"
+	"testing"
+
+	"github.com/3ssalunke/go-blockchain/types"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStateRoot(t *testing.T) {
+	s := NewState()
+	assert.True(t, s.Root().IsZero())
+
+	assert.Nil(t, s.Put([]byte("foo"), []byte("bar")))
+	one := s.Root()
+	assert.False(t, one.IsZero())
+
+	assert.Nil(t, s.Put([]byte("baz"), []byte("qux")))
+	two := s.Root()
+	assert.NotEqual(t, one, two)
+
+	other := NewState()
+	assert.Nil(t, other.Put([]byte("baz"), []byte("qux")))
+	assert.Nil(t, other.Put([]byte("foo"), []byte("bar")))
+	assert.Equal(t, two, other.Root())
+
+	assert.Nil(t, other.Put([]byte("foo"), []byte("changed")))
+	assert.NotEqual(t, two, other.Root())
+
+	assert.Nil(t, s.Delete([]byte("baz")))
+	assert.Equal(t, one, s.Root())
+}
+
+// fullStateRoot computes the state root of data from scratch.
+func fullStateRoot(data map[string][]byte) types.Hash {
+	leaves := []*stateNode{}
+	for k, v := range data {
+		leaves = append(leaves, newStateLeaf(k, v))
+	}
+	sort.Slice(leaves, func(i, j int) bool {
+		return bytes.Compare(leaves[i].path[:], leaves[j].path[:]) < 0
+	})
+
+	var subtree func(leaves []*stateNode, depth int) types.Hash
+	subtree = func(leaves []*stateNode, depth int) types.Hash {
+		switch len(leaves) {
+		case 0:
+			return types.Hash{}
+		case 1:
+			return leaves[0].hash
+		}
+		split := sort.Search(len(leaves), func(i int) bool {
+			return pathBit(leaves[i].path, depth) == 1
+		})
+		left, right := subtree(leaves[:split], depth+1), subtree(leaves[split:], depth+1)
+		return sha256.Sum256(append(append([]byte{stateNodePrefix}, left[:]...), right[:]...))
+	}
+
+	return subtree(leaves, 0)
+}
+
+func TestStateRootIncremental(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	s := NewState()
+
+	for round := 0; round < 50; round++ {
+		snapshot := s.Snapshot()
+		for i := 0; i < 20; i++ {
+			key := []byte(fmt.Sprintf("key%d", rnd.Intn(100)))
+			if rnd.Intn(3) == 0 {
+				assert.Nil(t, s.Delete(key))
+			} else {
+				assert.Nil(t, s.Put(key, []byte(fmt.Sprint(rnd.Int()))))
+			}
+		}
+		if round%5 == 4 {
+			s.RevertToSnapshot(snapshot)
+		}
+		s.Commit()
+
+		assert.Equal(t, fullStateRoot(s.data), s.Root())
+		assert.Equal(t, s.Root(), s.Copy().Root())
+	}
+}
+
+func TestStateRevert(t *testing.T) {
+	s := NewState()
+	assert.Nil(t, s.Put([]byte("foo"), []byte("bar")))
+	s.Commit()
+	root := s.Root()
+
+	assert.Nil(t, s.Put([]byte("foo"), []byte("baz")))
+	assert.Nil(t, s.Put([]byte("new"), []byte("value")))
+	assert.Nil(t, s.Delete([]byte("foo")))
+	s.Revert(s.Commit())
+
+	value, err := s.Get([]byte("foo"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("bar"), value)
+	_, err = s.Get([]byte("new"))
+	assert.NotNil(t, err)
+	assert.Equal(t, root, s.Root())
+}
+
+func TestStateSnapshot(t *testing.T) {
+	s := NewState()
+	assert.Nil(t, s.Put([]byte("a"), []byte("1")))
+
+	outer := s.Snapshot()
+	assert.Nil(t, s.Put([]byte("b"), []byte("2")))
+	inner := s.Snapshot()
+	assert.Nil(t, s.Put([]byte("a"), []byte("3")))
+
+	s.RevertToSnapshot(inner)
+	value, err := s.Get([]byte("a"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("1"), value)
+	_, err = s.Get([]byte("b"))
+	assert.Nil(t, err)
+
+	s.RevertToSnapshot(outer)
+	_, err = s.Get([]byte("b"))
+	assert.NotNil(t, err)
+
+	// Committed changes survive a revert to an earlier snapshot, but are
+	// still undone by their journal.
+	journal := s.Commit()
+	assert.Equal(t, 1, len(journal))
+	s.RevertToSnapshot(0)
+	_, err = s.Get([]byte("a"))
+	assert.Nil(t, err)
+
+	s.Revert(journal)
+	_, err = s.Get([]byte("a"))
+	assert.NotNil(t, err)
+}
+
+func TestStateDiff(t *testing.T) {
+	s := NewState()
+	assert.Nil(t, s.Put([]byte("a"), []byte("1")))
+	assert.Nil(t, s.Put([]byte("b"), []byte("2")))
+	s.Commit()
+
+	c := s.Copy()
+	snapshot := c.Snapshot()
+	assert.Nil(t, c.Put([]byte("a"), []byte("3")))
+	assert.Nil(t, c.Put([]byte("a"), []byte("4")))
+	assert.Nil(t, c.Delete([]byte("b")))
+	assert.Nil(t, c.Put([]byte("new"), []byte{}))
+	// Written back to its value, so left out.
+	assert.Nil(t, c.Put([]byte("tmp"), []byte("x")))
+	assert.Nil(t, c.Delete([]byte("tmp")))
+
+	assert.Equal(t, []StateDiff{
+		{Key: []byte("a"), Before: []byte("1"), After: []byte("4")},
+		{Key: []byte("b"), Before: []byte("2")},
+		{Key: []byte("new"), After: []byte{}},
+	}, c.Diff(snapshot))
+
+	// The copy does not share data with the original.
+	value, err := s.Get([]byte("a"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("1"), value)
+}
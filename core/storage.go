@@ -1,15 +1,146 @@
 package core
 
+import (
+	"fmt"
+	"sync"
+
+	"github.com/3ssalunke/go-blockchain/types"
+)
+
 type Storage interface {
 	Put(b *Block) error
+	Get(hash types.Hash) (*Block, error)
+	// GetByHeight returns the canonical block at height, as recorded with
+	// SetCanonical.
+	GetByHeight(height uint32) (*Block, error)
+	// SetCanonical records the block with the given hash, which must be
+	// stored, as the canonical block at height and drops the canonical
+	// blocks above it. The index is kept in memory and rebuilt by the
+	// chain when it loads the store.
+	SetCanonical(height uint32, hash types.Hash) error
+	// Iterate calls fn for every stored block in the order the blocks were
+	// written and stops at the first error returned by fn.
+	Iterate(fn func(*Block) error) error
+	// PutReceipts stores the receipts of the block with the given hash.
+	// Storing them again for the same block is a no-op.
+	PutReceipts(blockHash types.Hash, receipts []*Receipt) error
+	GetReceipts(blockHash types.Hash) ([]*Receipt, error)
+	Close() error
 }
 
-type MemStore struct{}
+type MemStore struct {
+	lock   sync.RWMutex
+	blocks []*Block
+	byHash map[types.Hash]*Block
+	// canonical holds the hash of the canonical block at every height.
+	canonical []types.Hash
+	receipts  map[types.Hash][]*Receipt
+}
 
 func NewMemStore() *MemStore {
-	return &MemStore{}
+	return &MemStore{
+		byHash:   make(map[types.Hash]*Block),
+		receipts: make(map[types.Hash][]*Receipt),
+	}
 }
 
 func (m *MemStore) Put(b *Block) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	m.blocks = append(m.blocks, b)
+	m.byHash[b.Hash(BlockHasher{})] = b
+
+	return nil
+}
+
+func (m *MemStore) Get(hash types.Hash) (*Block, error) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	b, ok := m.byHash[hash]
+	if !ok {
+		return nil, fmt.Errorf("block not found for hash %s", hash)
+	}
+
+	return b, nil
+}
+
+func (m *MemStore) GetByHeight(height uint32) (*Block, error) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	if int(height) >= len(m.canonical) {
+		return nil, fmt.Errorf("block not found for height %d", height)
+	}
+
+	return m.byHash[m.canonical[height]], nil
+}
+
+func (m *MemStore) SetCanonical(height uint32, hash types.Hash) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	if _, ok := m.byHash[hash]; !ok {
+		return fmt.Errorf("block not found for hash %s", hash)
+	}
+	canonical, err := setCanonical(m.canonical, height, hash)
+	if err != nil {
+		return err
+	}
+	m.canonical = canonical
+
+	return nil
+}
+
+func (m *MemStore) Iterate(fn func(*Block) error) error {
+	m.lock.RLock()
+	blocks := make([]*Block, len(m.blocks))
+	copy(blocks, m.blocks)
+	m.lock.RUnlock()
+
+	for _, b := range blocks {
+		if err := fn(b); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (m *MemStore) PutReceipts(blockHash types.Hash, receipts []*Receipt) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	if _, ok := m.receipts[blockHash]; !ok {
+		m.receipts[blockHash] = receipts
+	}
+
 	return nil
 }
+
+func (m *MemStore) GetReceipts(blockHash types.Hash) ([]*Receipt, error) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	receipts, ok := m.receipts[blockHash]
+	if !ok {
+		return nil, fmt.Errorf("receipts not found for block %s", blockHash)
+	}
+
+	return receipts, nil
+}
+
+func (m *MemStore) Close() error {
+	return nil
+}
+
+// setCanonical sets the hash at height in a canonical index and drops the
+// entries above it. height may be at most one above the last entry.
+func setCanonical(index []types.Hash, height uint32, hash types.Hash) ([]types.Hash, error) {
+	if int(height) > len(index) {
+		return nil, fmt.Errorf("canonical height %d skips height %d", height, len(index))
+	}
+
+	return append(index[:height], hash), nil
+}
@@ -0,0 +1,169 @@
+package core
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/3ssalunke/go-blockchain/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMemStore(t *testing.T) {
+	s := NewMemStore()
+	genesis := randomBlockWithSignature(t, 0, types.Hash{})
+
+	assert.Nil(t, s.Put(genesis))
+
+	b, err := s.Get(genesis.Hash(BlockHasher{}))
+	assert.Nil(t, err)
+	assert.Equal(t, genesis, b)
+
+	_, err = s.Get(types.RandomHash())
+	assert.NotNil(t, err)
+
+	_, err = s.GetByHeight(0)
+	assert.NotNil(t, err)
+	assert.Nil(t, s.SetCanonical(0, genesis.Hash(BlockHasher{})))
+	b, err = s.GetByHeight(0)
+	assert.Nil(t, err)
+	assert.Equal(t, genesis, b)
+
+	_, err = s.GetByHeight(1)
+	assert.NotNil(t, err)
+	assert.NotNil(t, s.SetCanonical(1, types.RandomHash()))
+}
+
+func TestFileStorePutGet(t *testing.T) {
+	s, err := NewFileStore(t.TempDir())
+	assert.Nil(t, err)
+	defer s.Close()
+
+	genesis := randomBlockWithSignature(t, 0, types.Hash{})
+	assert.Nil(t, s.Put(genesis))
+
+	b, err := s.Get(genesis.Hash(BlockHasher{}))
+	assert.Nil(t, err)
+	assert.Equal(t, genesis.Hash(BlockHasher{}), b.Hash(BlockHasher{}))
+	assert.Equal(t, len(genesis.Transactions), len(b.Transactions))
+
+	_, err = s.Get(types.RandomHash())
+	assert.NotNil(t, err)
+
+	assert.Nil(t, s.SetCanonical(0, genesis.Hash(BlockHasher{})))
+	b, err = s.GetByHeight(0)
+	assert.Nil(t, err)
+	assert.Equal(t, genesis.Hash(BlockHasher{}), b.Hash(BlockHasher{}))
+
+	_, err = s.GetByHeight(1)
+	assert.NotNil(t, err)
+}
+
+func TestFileStoreReopenBlockchain(t *testing.T) {
+	dir := t.TempDir()
+
+	store, err := NewFileStore(dir)
+	assert.Nil(t, err)
+	genesis := randomBlockWithSignature(t, 0, types.Hash{})
+	bc, err := NewBlockchain(genesis, &BlockchainOpts{Store: store})
+	assert.Nil(t, err)
+
+	for i := 1; i <= 3; i++ {
+		assert.Nil(t, bc.AddBlock(preparedBlock(t, bc, randomTxWithSignature(t))))
+	}
+	head, err := bc.GetBlockByHeight(3)
+	assert.Nil(t, err)
+	assert.Nil(t, store.Close())
+
+	store, err = NewFileStore(dir)
+	assert.Nil(t, err)
+	defer store.Close()
+
+	// A store holding another chain is refused.
+	_, err = NewBlockchain(randomBlockWithSignature(t, 0, types.Hash{}), &BlockchainOpts{Store: store})
+	assert.NotNil(t, err)
+
+	reopened, err := NewBlockchain(genesis, &BlockchainOpts{Store: store})
+	assert.Nil(t, err)
+	assert.Equal(t, uint32(3), reopened.Height())
+	stored, err := store.GetByHeight(3)
+	assert.Nil(t, err)
+	assert.Equal(t, head.Hash(BlockHasher{}), stored.Hash(BlockHasher{}))
+
+	b, err := reopened.GetBlockByHash(head.Hash(BlockHasher{}))
+	assert.Nil(t, err)
+	assert.Equal(t, uint32(3), b.Height)
+
+	tx, err := reopened.GetTxByHash(head.Transactions[0].Hash(TxHasher{}))
+	assert.Nil(t, err)
+	assert.Equal(t, head.Transactions[0].Data, tx.Data)
+
+	receipts, err := store.GetReceipts(head.Hash(BlockHasher{}))
+	assert.Nil(t, err)
+	assert.Equal(t, head.ReceiptsRoot, CalculateReceiptsRoot(receipts))
+}
+
+func TestFileStoreTruncatesPartialRecord(t *testing.T) {
+	dir := t.TempDir()
+
+	s, err := NewFileStore(dir)
+	assert.Nil(t, err)
+	genesis := randomBlockWithSignature(t, 0, types.Hash{})
+	assert.Nil(t, s.Put(genesis))
+	assert.Nil(t, s.Close())
+
+	f, err := os.OpenFile(filepath.Join(dir, blockFileName), os.O_WRONLY|os.O_APPEND, 0o644)
+	assert.Nil(t, err)
+	_, err = f.Write([]byte{0x00, 0x00, 0x01})
+	assert.Nil(t, err)
+	assert.Nil(t, f.Close())
+
+	s, err = NewFileStore(dir)
+	assert.Nil(t, err)
+	defer s.Close()
+
+	count := 0
+	assert.Nil(t, s.Iterate(func(*Block) error {
+		count++
+		return nil
+	}))
+	assert.Equal(t, 1, count)
+
+	second := randomBlockWithSignature(t, 1, genesis.Hash(BlockHasher{}))
+	assert.Nil(t, s.Put(second))
+	b, err := s.Get(second.Hash(BlockHasher{}))
+	assert.Nil(t, err)
+	assert.Equal(t, second.Hash(BlockHasher{}), b.Hash(BlockHasher{}))
+}
+
+func TestFileStoreReceipts(t *testing.T) {
+	dir := t.TempDir()
+	blockHash := types.RandomHash()
+	receipts := []*Receipt{
+		{TxHash: types.RandomHash(), Status: ReceiptStatusSuccess, GasUsed: 1200},
+		{
+			TxHash:  types.RandomHash(),
+			Status:  ReceiptStatusFailed,
+			GasUsed: 5000,
+			Logs:    []*Log{{Topics: []types.Hash{types.RandomHash()}, Data: []byte("foo")}},
+			Error:   "out of gas",
+		},
+	}
+
+	s, err := NewFileStore(dir)
+	assert.Nil(t, err)
+	assert.Nil(t, s.PutReceipts(blockHash, receipts))
+	assert.Nil(t, s.PutReceipts(blockHash, receipts[:1]))
+	assert.Nil(t, s.Close())
+
+	s, err = NewFileStore(dir)
+	assert.Nil(t, err)
+	defer s.Close()
+
+	stored, err := s.GetReceipts(blockHash)
+	assert.Nil(t, err)
+	assert.Equal(t, receipts, stored)
+
+	_, err = s.GetReceipts(types.RandomHash())
+	assert.NotNil(t, err)
+}
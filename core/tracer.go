@@ -0,0 +1,131 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/3ssalunke/go-blockchain/types"
+)
+
+// ErrTraceLimit is returned by TraceLogger once a trace outgrows its limits.
+var ErrTraceLimit = errors.New("trace limit exceeded")
+
+// Default limits of a TraceLogger.
+const (
+	DefaultTraceMaxSteps = 100_000
+	DefaultTraceMaxBytes = 32 << 20
+)
+
+// traceStepSize is what a step is counted for towards the byte limit of a
+// TraceLogger, on top of its stack and storage values.
+const traceStepSize = 128
+
+// Tracer follows the execution of a VM step by step, e.g. to debug a
+// transaction. A tracer set on a VM also receives the steps of the frames
+// it calls, each tagged with its depth.
+type Tracer interface {
+	// CaptureStep is called before an instruction is paid for and run.
+	// stack is the live stack of the VM, so a tracer must copy the values
+	// it keeps. An error aborts the whole execution, including the frames
+	// calling this one.
+	CaptureStep(step *TraceStep, stack *Stack) error
+	// CaptureStorage is called for every storage read, write and delete
+	// made by the instruction of the last step.
+	CaptureStorage(access *StorageAccess)
+	// CaptureFault is called when the last step of the frame at depth
+	// faults.
+	CaptureFault(depth int, err error)
+}
+
+// TraceStep is the VM state right before an instruction runs.
+type TraceStep struct {
+	Depth int
+	PC    int
+	Op    Instruction
+	// Gas is the gas left before the instruction and GasCost what the
+	// instruction is charged up front.
+	Gas     uint64
+	GasCost uint64
+	// Stack holds the stack values, bottom first. It is filled in by
+	// TraceLogger.
+	Stack []Value
+	// Storage and Err are filled in by TraceLogger as the step runs.
+	Storage []*StorageAccess
+	Err     error
+}
+
+// StorageAccess describes an access to the storage of a contract. Op is
+// InstrGet, InstrStore or InstrDelete. Value is the value read or written;
+// a read of a missing key yields an empty value.
+type StorageAccess struct {
+	Op      Instruction
+	Address types.Address
+	Key     []byte
+	Value   []byte
+}
+
+// TraceLogger is a Tracer that records every step along with its stack. It
+// aborts the execution with ErrTraceLimit once it holds more than MaxSteps
+// steps or roughly MaxBytes bytes.
+type TraceLogger struct {
+	Steps    []*TraceStep
+	MaxSteps int
+	MaxBytes int
+
+	size int
+	err  error
+}
+
+func NewTraceLogger() *TraceLogger {
+	return &TraceLogger{
+		Steps:    []*TraceStep{},
+		MaxSteps: DefaultTraceMaxSteps,
+		MaxBytes: DefaultTraceMaxBytes,
+	}
+}
+
+func (l *TraceLogger) CaptureStep(step *TraceStep, stack *Stack) error {
+	if l.err != nil {
+		return l.err
+	}
+
+	size := traceStepSize
+	for _, v := range stack.data[:stack.sp] {
+		size += v.size()
+	}
+	switch {
+	case len(l.Steps) == l.MaxSteps:
+		l.err = fmt.Errorf("%w: more than %d steps", ErrTraceLimit, l.MaxSteps)
+	case l.size+size > l.MaxBytes:
+		l.err = fmt.Errorf("%w: more than %d bytes", ErrTraceLimit, l.MaxBytes)
+	}
+	if l.err != nil {
+		return l.err
+	}
+
+	step.Stack = stack.Values()
+	l.Steps = append(l.Steps, step)
+	l.size += size
+
+	return nil
+}
+
+func (l *TraceLogger) CaptureStorage(access *StorageAccess) {
+	if len(l.Steps) == 0 {
+		return
+	}
+	step := l.Steps[len(l.Steps)-1]
+	step.Storage = append(step.Storage, access)
+	l.size += len(access.Key) + len(access.Value)
+}
+
+func (l *TraceLogger) CaptureFault(depth int, err error) {
+	// The faulting step is the last one of its frame; any later steps
+	// belong to the frames it called.
+	for i := len(l.Steps) - 1; i >= 0; i-- {
+		if l.Steps[i].Depth == depth {
+			l.Steps[i].Err = err
+			return
+		}
+	}
+}
@@ -0,0 +1,156 @@
+package core
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/3ssalunke/go-blockchain/crypto"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestVMTracer(t *testing.T) {
+	code := program(pushInt(7), pushBytes([]byte("k")), InstrStore, pushBytes([]byte("k")), InstrGet)
+	tracer := NewTraceLogger()
+	vm := NewVM(code, NewState(), 1000)
+	vm.SetTracer(tracer)
+	assert.Nil(t, vm.Run())
+
+	assert.Len(t, tracer.Steps, 5)
+	store := tracer.Steps[2]
+	assert.Equal(t, InstrStore, store.Op)
+	assert.Equal(t, 8, store.PC)
+	assert.Equal(t, 1000-GasQuick-GasFast-GasCopyByte, store.Gas)
+	assert.Equal(t, GasStore, store.GasCost)
+	assert.Equal(t, []Value{IntValue(7), BytesValue([]byte("k"))}, store.Stack)
+	assert.Equal(t, []*StorageAccess{{Op: InstrStore, Key: []byte("k"), Value: IntValue(7).Serialize()}}, store.Storage)
+
+	get := tracer.Steps[4]
+	assert.Equal(t, []*StorageAccess{{Op: InstrGet, Key: []byte("k"), Value: IntValue(7).Serialize()}}, get.Storage)
+	assert.Nil(t, get.Err)
+}
+
+func TestVMTracerCall(t *testing.T) {
+	state := NewState()
+	callee := deployCode(state, program(pushInt(1), InstrAdd))
+
+	tracer := NewTraceLogger()
+	vm := NewVM(program(callProgram(InstrCall, callee, nil), InstrPop), state, 100_000)
+	vm.SetTracer(tracer)
+	assert.Nil(t, vm.Run())
+
+	depths := []int{}
+	for _, step := range tracer.Steps {
+		depths = append(depths, step.Depth)
+	}
+	assert.Equal(t, []int{0, 0, 0, 0, 0, 0, 0, 1, 1, 0}, depths)
+
+	// The fault is recorded on the callee's step, not on the call.
+	assert.ErrorIs(t, tracer.Steps[8].Err, ErrStackUnderflow)
+	assert.Nil(t, tracer.Steps[6].Err)
+}
+
+func TestTraceTransaction(t *testing.T) {
+	bc := newBlockchainWithGenesis(t)
+	privKey := crypto.GeneratePrivateKey()
+
+	// The contract reads "v" and then stores its input there.
+	code := program(pushBytes([]byte("v")), InstrGet, InstrPop, InstrCallData, pushBytes([]byte("v")), InstrStore)
+	deploy := NewDeployTransaction(code)
+	assert.Nil(t, deploy.Sign(privKey))
+	assert.Nil(t, bc.AddBlock(preparedBlock(t, bc, deploy)))
+	addr := ContractAddress(privKey.PublicKey().Address(), 0)
+
+	calls := []*Transaction{}
+	for i, input := range []string{"a", "b", "c"} {
+		tx := NewCallTransaction(addr, []byte(input))
+		tx.Nonce = uint64(i + 1)
+		assert.Nil(t, tx.Sign(privKey))
+		calls = append(calls, tx)
+	}
+	assert.Nil(t, bc.AddBlock(preparedBlock(t, bc, calls[0], calls[1])))
+	assert.Nil(t, bc.AddBlock(preparedBlock(t, bc, calls[2])))
+	root := bc.contractState.Root()
+
+	// Each call is traced against the state it ran on, after the calls
+	// before it in the same block.
+	for i, want := range []string{"", "a", "b"} {
+		tracer := NewTraceLogger()
+		receipt, err := bc.TraceTransaction(calls[i].Hash(TxHasher{}), tracer)
+		assert.Nil(t, err)
+
+		expected, err := bc.GetReceipt(calls[i].Hash(TxHasher{}))
+		assert.Nil(t, err)
+		assert.Equal(t, expected, receipt)
+
+		assert.Len(t, tracer.Steps, 6)
+		assert.Equal(t, []byte(want), tracer.Steps[1].Storage[0].Value)
+	}
+
+	assert.Equal(t, root, bc.contractState.Root())
+
+	// Transfers and deploys run no code.
+	tracer := NewTraceLogger()
+	_, err := bc.TraceTransaction(deploy.Hash(TxHasher{}), tracer)
+	assert.Nil(t, err)
+	assert.Empty(t, tracer.Steps)
+
+	_, err = bc.TraceTransaction(randomTxWithSignature(t).Hash(TxHasher{}), tracer)
+	assert.NotNil(t, err)
+}
+
+func TestTraceLoggerLimits(t *testing.T) {
+	code, err := Assemble(benchLoop)
+	assert.Nil(t, err)
+
+	tracer := NewTraceLogger()
+	tracer.MaxSteps = 100
+	vm := NewVM(code, NewState(), 1_000_000)
+	vm.SetTracer(tracer)
+	assert.ErrorIs(t, vm.Run(), ErrTraceLimit)
+	assert.Len(t, tracer.Steps, 100)
+
+	// Stack values count towards the byte limit.
+	big := make([]byte, 1000)
+	code = program(pushBytes(big), pushBytes(big), pushBytes(big))
+	tracer = NewTraceLogger()
+	tracer.MaxBytes = 3*traceStepSize + 1500
+	vm = NewVM(code, NewState(), 1_000_000)
+	vm.SetTracer(tracer)
+	assert.ErrorIs(t, vm.Run(), ErrTraceLimit)
+	assert.Len(t, tracer.Steps, 2)
+}
+
+func TestTraceLoggerLimitsCall(t *testing.T) {
+	state := NewState()
+	code, err := Assemble(benchLoop)
+	assert.Nil(t, err)
+	callee := deployCode(state, code)
+
+	// Hitting the limit in a callee aborts the caller too rather than
+	// failing the call.
+	tracer := NewTraceLogger()
+	tracer.MaxSteps = 100
+	vm := NewVM(program(callProgram(InstrCall, callee, nil), pushInt(1)), state, 1_000_000)
+	vm.SetTracer(tracer)
+	err = vm.Run()
+	assert.ErrorIs(t, err, ErrTraceLimit)
+	var vmErr *VMError
+	assert.False(t, errors.As(err, &vmErr))
+	assert.Equal(t, 1, tracer.Steps[len(tracer.Steps)-1].Depth)
+}
+
+func TestTraceTransactionLimit(t *testing.T) {
+	bc := newBlockchainWithGenesis(t)
+	privKey := crypto.GeneratePrivateKey()
+
+	code, err := Assemble(benchLoop)
+	assert.Nil(t, err)
+	tx := NewTransaction(code)
+	assert.Nil(t, tx.Sign(privKey))
+	assert.Nil(t, bc.AddBlock(preparedBlock(t, bc, tx)))
+
+	tracer := NewTraceLogger()
+	tracer.MaxSteps = 100
+	_, err = bc.TraceTransaction(tx.Hash(TxHasher{}), tracer)
+	assert.ErrorIs(t, err, ErrTraceLimit)
+}
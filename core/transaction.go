@@ -2,19 +2,62 @@ package core
 
 import (
 	"fmt"
-	"math/rand"
 	"time"
 
 	"github.com/3ssalunke/go-blockchain/crypto"
 	"github.com/3ssalunke/go-blockchain/types"
+	"github.com/3ssalunke/go-blockchain/util"
+)
+
+// txSigningDomain prefixes the signing payload so a transaction signature
+// can never be mistaken for a signature over other data.
+const txSigningDomain = "tx/v1"
+
+// Intrinsic gas is charged for every transaction before it executes, to
+// pay for verifying it and for the bytes it adds to the chain.
+const (
+	TxBaseGas     uint64 = 1000
+	TxDataByteGas uint64 = 10
+	// TxCodeByteGas is charged on top of TxDataByteGas for every byte of
+	// code a deploy transaction stores.
+	TxCodeByteGas uint64 = 200
+	// DefaultTxGasLimit is the gas limit NewTransaction sets.
+	DefaultTxGasLimit uint64 = 100_000
+)
+
+type TxType byte
+
+const (
+	// TxTypeExec runs Data as VM bytecode against the sender's own
+	// storage.
+	TxTypeExec TxType = iota
+	// TxTypeTransfer moves Value from the sender's account to To.
+	TxTypeTransfer
+	// TxTypeDeploy stores Data as the code of a new contract at
+	// ContractAddress(sender, Nonce) and moves Value to it.
+	TxTypeDeploy
+	// TxTypeCall moves Value to the contract at To and runs its code with
+	// Data as input.
+	TxTypeCall
 )
 
 type Transaction struct {
-	Data []byte
+	// ChainID binds the transaction to one network so it cannot be
+	// replayed on another.
+	ChainID uint32
+	Type    TxType
+	Data    []byte
+	To      types.Address
+	Value   uint64
 
 	From      crypto.PublicKey
 	Signature *crypto.Signature
-	Nonce     int64
+	// Nonce is the number of transactions the sender has already had
+	// included in the chain.
+	Nonce uint64
+	// GasLimit is the most gas the transaction may consume, including its
+	// intrinsic gas.
+	GasLimit uint64
 
 	hash      types.Hash
 	firstSeen int64
@@ -23,18 +66,60 @@ type Transaction struct {
 func NewTransaction(data []byte) *Transaction {
 	return &Transaction{
 		Data:      data,
+		GasLimit:  DefaultTxGasLimit,
+		firstSeen: time.Now().UnixNano(),
+	}
+}
+
+func NewTransferTransaction(to types.Address, value uint64) *Transaction {
+	return &Transaction{
+		Type:      TxTypeTransfer,
+		To:        to,
+		Value:     value,
+		GasLimit:  TxBaseGas,
+		firstSeen: time.Now().UnixNano(),
+	}
+}
+
+func NewDeployTransaction(code []byte) *Transaction {
+	tx := &Transaction{
+		Type:      TxTypeDeploy,
+		Data:      code,
 		firstSeen: time.Now().UnixNano(),
-		Nonce:     rand.Int63n(1000000000000000000),
 	}
+	tx.GasLimit = tx.IntrinsicGas()
+	return tx
+}
+
+func NewCallTransaction(to types.Address, input []byte) *Transaction {
+	return &Transaction{
+		Type:      TxTypeCall,
+		To:        to,
+		Data:      input,
+		GasLimit:  DefaultTxGasLimit,
+		firstSeen: time.Now().UnixNano(),
+	}
+}
+
+// IntrinsicGas returns the gas charged for the transaction before any of its
+// code runs.
+func (tx *Transaction) IntrinsicGas() uint64 {
+	gas := TxBaseGas + uint64(len(tx.Data))*TxDataByteGas
+	if tx.Type == TxTypeDeploy {
+		gas += uint64(len(tx.Data)) * TxCodeByteGas
+	}
+	return gas
 }
 
 func (tx *Transaction) Sign(privKey crypto.PrivateKey) error {
-	sig, err := privKey.Sign(tx.Data)
+	tx.From = privKey.PublicKey()
+	tx.hash = types.Hash{}
+
+	sig, err := privKey.Sign(tx.SigningPayload())
 	if err != nil {
 		return err
 	}
 
-	tx.From = privKey.PublicKey()
 	tx.Signature = sig
 
 	return nil
@@ -52,13 +137,23 @@ func (tx *Transaction) Verify() error {
 		return fmt.Errorf("transaction has no signature")
 	}
 
-	if !tx.Signature.Verify(tx.From, tx.Data) {
+	if !tx.Signature.Verify(tx.From, tx.SigningPayload()) {
 		return fmt.Errorf("invalid transaction signature")
 	}
 
 	return nil
 }
 
+// SigningPayload returns the canonical encoding of every consensus-relevant
+// field of the transaction. It is what the sender signs and what TxHasher
+// hashes, so no field can be altered without invalidating both.
+func (tx *Transaction) SigningPayload() []byte {
+	w := util.NewBinaryWriter()
+	w.WriteFixed([]byte(txSigningDomain))
+	writeTxFields(w, tx)
+	return w.Bytes()
+}
+
 func (tx *Transaction) Decode(dec Decoder[*Transaction]) error {
 	return dec.Decode(tx)
 }
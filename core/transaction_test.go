@@ -5,6 +5,7 @@ import (
 	"testing"
 
 	"github.com/3ssalunke/go-blockchain/crypto"
+	"github.com/3ssalunke/go-blockchain/types"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -34,20 +35,53 @@ func TestVerifyTransaction(t *testing.T) {
 	assert.NotNil(t, tx.Verify())
 }
 
+func TestVerifyTransactionCoversEnvelope(t *testing.T) {
+	tamper := []func(tx *Transaction){
+		func(tx *Transaction) { tx.ChainID++ },
+		func(tx *Transaction) { tx.Nonce++ },
+		func(tx *Transaction) { tx.Type = TxTypeTransfer },
+		func(tx *Transaction) { tx.To = types.AddressFromBytes(types.RandomBytes(20)) },
+		func(tx *Transaction) { tx.Value++ },
+		func(tx *Transaction) { tx.Data = []byte("bar") },
+	}
+
+	for _, fn := range tamper {
+		tx := randomTxWithSignature(t)
+		assert.Nil(t, tx.Verify())
+		fn(tx)
+		assert.NotNil(t, tx.Verify())
+	}
+}
+
+func TestTxHashIncludesSender(t *testing.T) {
+	a := &Transaction{Data: []byte("foo")}
+	b := &Transaction{Data: []byte("foo")}
+	assert.Nil(t, a.Sign(crypto.GeneratePrivateKey()))
+	assert.Nil(t, b.Sign(crypto.GeneratePrivateKey()))
+
+	assert.NotEqual(t, a.Hash(TxHasher{}), b.Hash(TxHasher{}))
+
+	// The hash does not depend on the signature.
+	hash := TxHasher{}.Hash(a)
+	a.Signature = b.Signature
+	assert.Equal(t, hash, TxHasher{}.Hash(a))
+}
+
 func TestTxEncodeDecode(t *testing.T) {
 	tx := randomTxWithSignature(t)
 	buf := &bytes.Buffer{}
-	assert.Nil(t, tx.Encode(NewGobTxEncoder(buf)))
+	assert.Nil(t, tx.Encode(NewBinaryTxEncoder(buf)))
 
 	txDecoded := new(Transaction)
-	assert.Nil(t, txDecoded.Decode(NewGobTxDecoder(buf)))
+	assert.Nil(t, txDecoded.Decode(NewBinaryTxDecoder(buf)))
 	assert.Equal(t, tx, txDecoded)
 }
 
 func randomTxWithSignature(t *testing.T) *Transaction {
 	privKey := crypto.GeneratePrivateKey()
 	tx := &Transaction{
-		Data: []byte("foo"),
+		Data:     []byte("foo"),
+		GasLimit: DefaultTxGasLimit,
 	}
 	assert.Nil(t, tx.Sign(privKey))
 	return tx
@@ -17,21 +17,31 @@ func NewBlockValidator(bc *Blockchain) *BlockValidtor {
 }
 
 func (v *BlockValidtor) ValidateBlock(b *Block) error {
-	if v.bc.HasBlock(b.Height) {
-		return fmt.Errorf("chain already contains block (%d) with hash (%s)", b.Height, b.Hash(BlockHasher{}))
+	hash := b.Hash(BlockHasher{})
+	if _, err := v.bc.GetBlockByHash(hash); err == nil {
+		return fmt.Errorf("chain already contains block (%d) with hash (%s)", b.Height, hash)
 	}
 
-	if b.Height != v.bc.Height()+1 {
-		return fmt.Errorf("block (%s) is too high", b.Hash(BlockHasher{}))
+	parent, err := v.bc.GetBlockByHash(b.PrevBlockHash)
+	if err != nil {
+		return fmt.Errorf("the previous block {%s} of block (%s) is unknown", b.PrevBlockHash, hash)
 	}
 
-	prevHeader, err := v.bc.GetHeader(b.Height - 1)
-	if err != nil {
-		return err
+	if b.Height != parent.Height+1 {
+		return fmt.Errorf("block (%s) has height %d, expected %d", hash, b.Height, parent.Height+1)
 	}
-	hash := BlockHasher{}.Hash(prevHeader)
-	if hash != b.PrevBlockHash {
-		return fmt.Errorf("the hash of the previous block {%s} is invalid", hash)
+
+	if b.GasLimit > v.bc.BlockGasLimit() {
+		return fmt.Errorf("block (%s) has gas limit %d, maximum is %d", hash, b.GasLimit, v.bc.BlockGasLimit())
+	}
+	if b.GasUsed > b.GasLimit {
+		return fmt.Errorf("block (%s) used %d gas, above its gas limit %d", hash, b.GasUsed, b.GasLimit)
+	}
+
+	for _, tx := range b.Transactions {
+		if tx.ChainID != v.bc.ChainID() {
+			return fmt.Errorf("transaction (%s) has chain id %d, expected %d", tx.Hash(TxHasher{}), tx.ChainID, v.bc.ChainID())
+		}
 	}
 
 	if err := b.Verify(); err != nil {
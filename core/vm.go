@@ -1,134 +1,822 @@
 package core
 
 import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
 	"fmt"
 
-	"github.com/3ssalunke/go-blockchain/util"
+	"github.com/3ssalunke/go-blockchain/types"
 )
 
 type Instruction byte
 
+// Push instructions take their value from the immediate that follows them.
 const (
-	InstrPushInt  Instruction = 0x0a
-	InstrPushByte Instruction = 0x0b
+	InstrPushInt   Instruction = 0x0a
+	InstrPushByte  Instruction = 0x0b
+	InstrPushInt32 Instruction = 0x0c
+	InstrPushInt64 Instruction = 0x0d
+	InstrPushBytes Instruction = 0x0e
+)
+
+const (
+	InstrPack   Instruction = 0x10
+	InstrPop    Instruction = 0x13
+	InstrDup    Instruction = 0x14
+	InstrSwap   Instruction = 0x15
+	InstrConcat Instruction = 0x16
+	InstrSlice  Instruction = 0x17
+	InstrLen    Instruction = 0x18
 
-	InstrPack Instruction = 0x10
+	InstrStore  Instruction = 0x20
+	InstrGet    Instruction = 0x21
+	InstrDelete Instruction = 0x22
 
-	InstrStore Instruction = 0x20
-	InstrGet   Instruction = 0x21
+	InstrCallData Instruction = 0x28
+	InstrCaller   Instruction = 0x29
+	InstrAddress  Instruction = 0x2a
 
 	InstrAdd Instruction = 0x30
 	InstrSub Instruction = 0x32
 	InstrMul Instruction = 0x33
 	InstrDiv Instruction = 0x34
+
+	InstrEq  Instruction = 0x80
+	InstrLt  Instruction = 0x81
+	InstrGt  Instruction = 0x82
+	InstrAnd Instruction = 0x83
+	InstrOr  Instruction = 0x84
+	InstrNot Instruction = 0x85
+
+	InstrJump     Instruction = 0x90
+	InstrJumpI    Instruction = 0x91
+	InstrJumpDest Instruction = 0x92
+
+	InstrLog Instruction = 0xa0
+
+	InstrCall       Instruction = 0xb0
+	InstrStaticCall Instruction = 0xb1
+
+	InstrReturn Instruction = 0xf3
+	InstrRevert Instruction = 0xfd
 )
 
-type Stack struct {
-	data []any
-	sp   int
+// maxStackDepth is the number of values the VM stack can hold.
+const maxStackDepth = 128
+
+// maxValueSize is the length of the longest byte string a stack value can
+// hold.
+const maxValueSize = 64 << 10
+
+// maxCallDepth is the number of frames that can be nested by calls. A call
+// beyond it fails without running.
+const maxCallDepth = 64
+
+// Faults reported by VM.Run, always wrapped in a *VMError.
+var (
+	// ErrOutOfGas means executing the next instruction would exceed the gas
+	// limit.
+	ErrOutOfGas = errors.New("out of gas")
+	// ErrReverted means the program executed InstrRevert.
+	ErrReverted       = errors.New("execution reverted")
+	ErrStackUnderflow = errors.New("stack underflow")
+	ErrStackOverflow  = errors.New("stack overflow")
+	// ErrValueSize means a byte string would be longer than maxValueSize.
+	ErrValueSize      = errors.New("value too large")
+	ErrInvalidOperand = errors.New("invalid operand")
+	ErrDivisionByZero = errors.New("division by zero")
+	ErrInvalidOpcode  = errors.New("invalid opcode")
+	ErrInvalidCode    = errors.New("invalid code")
+	ErrInvalidJump    = errors.New("invalid jump destination")
+	// ErrWriteProtection means code running in a static call tried to
+	// change the state.
+	ErrWriteProtection = errors.New("state change in static call")
+	// ErrCallDepth means a call would nest more than maxCallDepth frames.
+	ErrCallDepth = errors.New("max call depth exceeded")
+)
+
+// VMError describes a fault that stopped the VM.
+type VMError struct {
+	Instr Instruction
+	PC    int
+	Err   error
 }
 
-func NewStack(size int) *Stack {
-	return &Stack{
-		data: make([]any, size),
-		sp:   0,
-	}
+func (e *VMError) Error() string {
+	return fmt.Sprintf("vm: %s at pc %d (instruction 0x%02x)", e.Err, e.PC, byte(e.Instr))
 }
 
-func (s *Stack) Push(v any) {
-	// s.data[s.sp] = v
-	s.data = append([]any{v}, s.data...)
-	s.sp++
+func (e *VMError) Unwrap() error {
+	return e.Err
 }
 
-func (s *Stack) Pop() any {
-	value := s.data[0]
-	s.data = append(s.data[:0], s.data[1:]...)
-	s.sp--
-	return value
+// Gas charged for an instruction by VM.Run. Writing to the contract state is
+// by far the most expensive operation since every write is kept forever.
+const (
+	GasQuick uint64 = 1
+	GasFast  uint64 = 3
+	GasMid   uint64 = 5
+	GasSlow  uint64 = 8
+	GasGet   uint64 = 50
+	GasStore uint64 = 200
+	// GasStoreByte is charged on top of GasStore for every byte of the key
+	// and value written.
+	GasStoreByte uint64 = 16
+	GasLog       uint64 = 100
+	// GasLogByte is charged on top of GasLog for every byte of log data.
+	GasLogByte uint64 = 8
+	// GasCopyByte is charged on top of the instruction's gas for every byte
+	// of the byte string made by InstrPushBytes, InstrConcat or InstrPack.
+	GasCopyByte uint64 = 1
+	// GasCall is charged for a call on top of the gas the callee uses.
+	GasCall uint64 = 100
+)
+
+var instrGas = map[Instruction]uint64{
+	InstrPushInt:    GasQuick,
+	InstrPushByte:   GasQuick,
+	InstrPushInt32:  GasQuick,
+	InstrPushInt64:  GasQuick,
+	InstrPushBytes:  GasFast,
+	InstrPack:       GasFast,
+	InstrPop:        GasQuick,
+	InstrDup:        GasQuick,
+	InstrSwap:       GasQuick,
+	InstrConcat:     GasFast,
+	InstrSlice:      GasFast,
+	InstrLen:        GasQuick,
+	InstrStore:      GasStore,
+	InstrGet:        GasGet,
+	InstrDelete:     GasStore,
+	InstrCallData:   GasQuick,
+	InstrCaller:     GasQuick,
+	InstrAddress:    GasQuick,
+	InstrAdd:        GasFast,
+	InstrSub:        GasFast,
+	InstrMul:        GasMid,
+	InstrDiv:        GasMid,
+	InstrEq:         GasFast,
+	InstrLt:         GasFast,
+	InstrGt:         GasFast,
+	InstrAnd:        GasFast,
+	InstrOr:         GasFast,
+	InstrNot:        GasFast,
+	InstrJump:       GasMid,
+	InstrJumpI:      GasSlow,
+	InstrJumpDest:   GasQuick,
+	InstrLog:        GasLog,
+	InstrCall:       GasCall,
+	InstrStaticCall: GasCall,
+	InstrReturn:     GasQuick,
+	InstrRevert:     GasQuick,
+}
+
+// Gas returns the cost of executing the instruction.
+func (instr Instruction) Gas() uint64 {
+	return instrGas[instr]
+}
+
+// Valid reports whether instr is a known instruction.
+func (instr Instruction) Valid() bool {
+	_, ok := instrGas[instr]
+	return ok
 }
 
 type VM struct {
-	data          []byte
+	data []byte
+	// pc is the offset of the instruction being executed and ip the offset
+	// of the next one.
+	pc            int
 	ip            int
+	jumpdests     []bool
 	stack         *Stack
 	contractState *State
+	gasLimit      uint64
+	gasUsed       uint64
+	halted        bool
+	returnValue   []byte
+	// address is the address whose code is running. Its storage is the one
+	// the code reads and writes, and it is recorded in the logs the code
+	// emits.
+	address types.Address
+	// caller is the address that started the execution and input the data
+	// it passed along.
+	caller types.Address
+	input  []byte
+	logs   []*Log
+	// depth is the number of calls the VM is nested in and static whether
+	// it, or any frame that called it, is a static call.
+	depth  int
+	static bool
+	tracer Tracer
 }
 
-func NewVM(data []byte, contractState *State) *VM {
+func NewVM(data []byte, contractState *State, gasLimit uint64) *VM {
 	return &VM{
 		data:          data,
 		ip:            0,
-		stack:         NewStack(128),
+		stack:         NewStack(),
 		contractState: contractState,
+		gasLimit:      gasLimit,
 	}
 }
 
+// Run validates the program and executes it until its end or until it
+// returns or reverts. Every instruction is paid for before it runs; once the
+// gas limit is reached Run stops with ErrOutOfGas and all the gas is
+// considered used. Any fault is returned as a *VMError; an error from the
+// tracer is returned as is.
 func (vm *VM) Run() error {
-	for {
-		instr := vm.data[vm.ip]
-
-		if err := vm.Exec(Instruction(instr)); err != nil {
+	if vm.jumpdests == nil {
+		jumpdests, err := analyzeCode(vm.data)
+		if err != nil {
 			return err
 		}
+		vm.jumpdests = jumpdests
+	}
+
+	for vm.ip < len(vm.data) && !vm.halted {
+		vm.pc = vm.ip
+		instr := Instruction(vm.data[vm.pc])
+		n, _ := immediateSize(vm.data, vm.pc)
+		vm.ip += 1 + n
+
+		if vm.tracer != nil {
+			step := &TraceStep{
+				Depth:   vm.depth,
+				PC:      vm.pc,
+				Op:      instr,
+				Gas:     vm.gasLimit - vm.gasUsed,
+				GasCost: instr.Gas(),
+			}
+			if err := vm.tracer.CaptureStep(step, vm.stack); err != nil {
+				return &traceAbort{err: err}
+			}
+		}
 
-		vm.ip++
+		if err := vm.useGas(instr.Gas()); err != nil {
+			return vm.fault(instr, err)
+		}
 
-		if vm.ip > len(vm.data)-1 {
-			break
+		if err := vm.Exec(instr); err != nil {
+			return vm.fault(instr, err)
 		}
 	}
 
 	return nil
 }
 
-func (vm *VM) Exec(instr Instruction) error {
-	fmt.Println(instr)
+// SetTracer makes the VM report every step it executes, including those of
+// the frames it calls, to tracer.
+func (vm *VM) SetTracer(tracer Tracer) {
+	vm.tracer = tracer
+}
+
+// traceAbort is the error a tracer aborted the execution with. It is not
+// a fault of the code: it goes through the frames calling the aborted one
+// untouched.
+type traceAbort struct {
+	err error
+}
+
+func (e *traceAbort) Error() string {
+	return fmt.Sprintf("trace aborted: %s", e.err)
+}
+
+func (e *traceAbort) Unwrap() error {
+	return e.err
+}
+
+func (vm *VM) fault(instr Instruction, err error) error {
+	var abort *traceAbort
+	if errors.As(err, &abort) {
+		return err
+	}
+
+	vmErr := &VMError{Instr: instr, PC: vm.pc, Err: err}
+	if vm.tracer != nil {
+		vm.tracer.CaptureFault(vm.depth, vmErr)
+	}
+	return vmErr
+}
+
+func (vm *VM) traceStorage(op Instruction, key, value []byte) {
+	if vm.tracer != nil {
+		vm.tracer.CaptureStorage(&StorageAccess{
+			Op:      op,
+			Address: vm.address,
+			Key:     key,
+			Value:   value,
+		})
+	}
+}
+
+// GasUsed returns the gas consumed by the instructions executed so far.
+func (vm *VM) GasUsed() uint64 {
+	return vm.gasUsed
+}
+
+// Logs returns the logs emitted so far.
+func (vm *VM) Logs() []*Log {
+	return vm.logs
+}
+
+// ReturnValue returns the value passed to InstrReturn or InstrRevert.
+func (vm *VM) ReturnValue() []byte {
+	return vm.returnValue
+}
+
+func (vm *VM) useGas(gas uint64) error {
+	if vm.gasLimit-vm.gasUsed < gas {
+		vm.gasUsed = vm.gasLimit
+		return ErrOutOfGas
+	}
+	vm.gasUsed += gas
+	return nil
+}
 
+func (vm *VM) Exec(instr Instruction) error {
 	switch instr {
 	case InstrStore:
-		var (
-			key             = vm.stack.Pop().([]byte)
-			value           = vm.stack.Pop()
-			serializedValue []byte
-		)
-		switch v := value.(type) {
-		case int:
-			serializedValue = util.SerializeInt64(int64(v))
-		default:
-			panic("TODO: unknown type")
-		}
-		vm.contractState.Put(key, serializedValue)
-	case InstrPushInt:
-		vm.stack.Push(int(vm.data[vm.ip-1]))
-	case InstrPushByte:
-		vm.stack.Push(byte(vm.data[vm.ip-1]))
+		if vm.static {
+			return ErrWriteProtection
+		}
+		key, err := vm.popBytes()
+		if err != nil {
+			return err
+		}
+		value, err := vm.popValue()
+		if err != nil {
+			return err
+		}
+		if err := vm.useGas(uint64(len(key)+len(value)) * GasStoreByte); err != nil {
+			return err
+		}
+		vm.traceStorage(instr, key, value)
+		return vm.contractState.PutStorage(vm.address, key, value)
+	case InstrGet:
+		key, err := vm.popBytes()
+		if err != nil {
+			return err
+		}
+		value, err := vm.contractState.GetStorage(vm.address, key)
+		if err != nil {
+			value = []byte{}
+		}
+		vm.traceStorage(instr, key, value)
+		return vm.stack.Push(BytesValue(value))
+	case InstrDelete:
+		if vm.static {
+			return ErrWriteProtection
+		}
+		key, err := vm.popBytes()
+		if err != nil {
+			return err
+		}
+		vm.traceStorage(instr, key, nil)
+		return vm.contractState.DeleteStorage(vm.address, key)
+	case InstrCallData:
+		return vm.stack.Push(BytesValue(vm.input))
+	case InstrCaller:
+		return vm.stack.Push(BytesValue(vm.caller.ToSlice()))
+	case InstrAddress:
+		return vm.stack.Push(BytesValue(vm.address.ToSlice()))
+	case InstrPushInt, InstrPushByte, InstrPushInt32, InstrPushInt64:
+		return vm.stack.Push(vm.immediate(instr))
+	case InstrPushBytes:
+		if err := vm.allocBytes(vm.ip - vm.pc - 5); err != nil {
+			return err
+		}
+		return vm.stack.Push(vm.immediate(instr))
 	case InstrPack:
-		n := vm.stack.Pop().(int)
-		b := make([]byte, n)
+		n, err := vm.popInt()
+		if err != nil {
+			return err
+		}
+		if n < 0 || n > int64(vm.stack.Len()) {
+			return fmt.Errorf("%w: cannot pack %d values from a stack of %d", ErrInvalidOperand, n, vm.stack.Len())
+		}
+
+		// The values are concatenated in the order they are popped.
+		values := make([][]byte, n)
+		size := 0
+		for i := range values {
+			v, err := vm.popBytes()
+			if err != nil {
+				return err
+			}
+			values[i] = v
+			size += len(v)
+		}
+		if err := vm.allocBytes(size); err != nil {
+			return err
+		}
+
+		b := make([]byte, 0, size)
+		for _, v := range values {
+			b = append(b, v...)
+		}
+		return vm.stack.Push(BytesValue(b))
+	case InstrPop:
+		_, err := vm.stack.Pop()
+		return err
+	case InstrDup:
+		v, err := vm.stack.Pop()
+		if err != nil {
+			return err
+		}
+		if err := vm.stack.Push(v); err != nil {
+			return err
+		}
+		return vm.stack.Push(v)
+	case InstrSwap:
+		a, err := vm.stack.Pop()
+		if err != nil {
+			return err
+		}
+		b, err := vm.stack.Pop()
+		if err != nil {
+			return err
+		}
+		if err := vm.stack.Push(a); err != nil {
+			return err
+		}
+		return vm.stack.Push(b)
+	case InstrConcat:
+		a, err := vm.popBytes()
+		if err != nil {
+			return err
+		}
+		b, err := vm.popBytes()
+		if err != nil {
+			return err
+		}
+		if err := vm.allocBytes(len(a) + len(b)); err != nil {
+			return err
+		}
+		c := make([]byte, 0, len(a)+len(b))
+		return vm.stack.Push(BytesValue(append(append(c, a...), b...)))
+	case InstrSlice:
+		b, err := vm.popBytes()
+		if err != nil {
+			return err
+		}
+		start, err := vm.popInt()
+		if err != nil {
+			return err
+		}
+		end, err := vm.popInt()
+		if err != nil {
+			return err
+		}
+		if start < 0 || start > end || end > int64(len(b)) {
+			return fmt.Errorf("%w: slice bounds [%d:%d] out of range for length %d", ErrInvalidOperand, start, end, len(b))
+		}
+		return vm.stack.Push(BytesValue(b[start:end:end]))
+	case InstrLen:
+		b, err := vm.popBytes()
+		if err != nil {
+			return err
+		}
+		return vm.stack.Push(IntValue(int64(len(b))))
+	case InstrAdd, InstrSub, InstrMul, InstrDiv, InstrLt, InstrGt, InstrAnd, InstrOr:
+		c, err := vm.popInt()
+		if err != nil {
+			return err
+		}
+		d, err := vm.popInt()
+		if err != nil {
+			return err
+		}
+		a, err := arithmetic(instr, c, d)
+		if err != nil {
+			return err
+		}
+		return vm.stack.Push(IntValue(a))
+	case InstrEq:
+		a, err := vm.popValue()
+		if err != nil {
+			return err
+		}
+		b, err := vm.popValue()
+		if err != nil {
+			return err
+		}
+		return vm.stack.Push(IntValue(boolToInt(bytes.Equal(a, b))))
+	case InstrNot:
+		a, err := vm.popInt()
+		if err != nil {
+			return err
+		}
+		return vm.stack.Push(IntValue(boolToInt(a == 0)))
+	case InstrJump:
+		dest, err := vm.popInt()
+		if err != nil {
+			return err
+		}
+		return vm.jump(dest)
+	case InstrJumpI:
+		dest, err := vm.popInt()
+		if err != nil {
+			return err
+		}
+		cond, err := vm.popInt()
+		if err != nil {
+			return err
+		}
+		if cond != 0 {
+			return vm.jump(dest)
+		}
+	case InstrReturn, InstrRevert:
+		value, err := vm.popValue()
+		if err != nil {
+			return err
+		}
+		vm.returnValue = value
+		vm.halted = true
+		if instr == InstrRevert {
+			return ErrReverted
+		}
+	case InstrLog:
+		if vm.static {
+			return ErrWriteProtection
+		}
+		return vm.log()
+	case InstrCall, InstrStaticCall:
+		return vm.call(instr == InstrStaticCall)
+	case InstrJumpDest:
+	default:
+		return ErrInvalidOpcode
+	}
+
+	return nil
+}
 
-		for i := 0; i < n; i++ {
-			b[i] = vm.stack.Pop().(byte)
+// log pops the number of topics, the topics and the data, and emits a log.
+// Topics are stored as the sha256 hash of their value.
+func (vm *VM) log() error {
+	n, err := vm.popInt()
+	if err != nil {
+		return err
+	}
+	if n < 0 || n > maxLogTopics {
+		return fmt.Errorf("%w: log with %d topics, maximum is %d", ErrInvalidOperand, n, maxLogTopics)
+	}
+
+	topics := make([]types.Hash, n)
+	for i := range topics {
+		v, err := vm.popValue()
+		if err != nil {
+			return err
+		}
+		topics[i] = types.Hash(sha256.Sum256(v))
+	}
+
+	data, err := vm.popValue()
+	if err != nil {
+		return err
+	}
+	if err := vm.useGas(uint64(len(data)) * GasLogByte); err != nil {
+		return err
+	}
+	if len(vm.logs) == maxLogs {
+		return fmt.Errorf("%w: too many logs", ErrInvalidOperand)
+	}
+
+	vm.logs = append(vm.logs, &Log{
+		Address: vm.address,
+		Topics:  topics,
+		Data:    data,
+	})
+
+	return nil
+}
+
+// call pops the callee address, the input and the gas allowance, plus for
+// InstrCall the value to send along, and runs the code at the callee in a
+// new frame with its own stack. The callee cannot use more gas than the
+// caller has left. Its return value and 1 on success, or 0 on failure, are
+// pushed in that order. A failing callee does not fault the caller but all
+// its state changes, logs and the value sent are rolled back.
+func (vm *VM) call(static bool) error {
+	addrBytes, err := vm.popBytes()
+	if err != nil {
+		return err
+	}
+	if len(addrBytes) != 20 {
+		return fmt.Errorf("%w: call address has length %d", ErrInvalidOperand, len(addrBytes))
+	}
+	input, err := vm.popValue()
+	if err != nil {
+		return err
+	}
+	gas, err := vm.popInt()
+	if err != nil {
+		return err
+	}
+	var value int64
+	if !static {
+		if value, err = vm.popInt(); err != nil {
+			return err
 		}
+	}
+	if gas < 0 || value < 0 {
+		return fmt.Errorf("%w: call with gas %d and value %d", ErrInvalidOperand, gas, value)
+	}
+	if vm.static && value > 0 {
+		return ErrWriteProtection
+	}
 
-		vm.stack.Push(b)
+	allowance := vm.gasLimit - vm.gasUsed
+	if uint64(gas) < allowance {
+		allowance = uint64(gas)
+	}
+
+	addr := types.AddressFromBytes(addrBytes)
+	ret, callErr := vm.runFrame(addr, input, uint64(value), allowance, static)
+	var abort *traceAbort
+	if errors.As(callErr, &abort) {
+		return callErr
+	}
+	if err := vm.stack.Push(BytesValue(ret)); err != nil {
+		return err
+	}
+	return vm.stack.Push(IntValue(boolToInt(callErr == nil)))
+}
+
+// runFrame runs the code at addr as a call from vm and charges vm for the
+// gas it used. On failure the state is reverted to before the call.
+func (vm *VM) runFrame(addr types.Address, input []byte, value, gas uint64, static bool) ([]byte, error) {
+	if vm.depth == maxCallDepth {
+		return []byte{}, ErrCallDepth
+	}
+
+	snapshot := vm.contractState.Snapshot()
+	if value > 0 {
+		if err := vm.contractState.Transfer(vm.address, addr, value); err != nil {
+			vm.contractState.RevertToSnapshot(snapshot)
+			return []byte{}, err
+		}
+	}
+
+	if p, ok := precompiles[addr]; ok {
+		ret, err := vm.runPrecompile(p, input, gas)
+		if err != nil {
+			vm.contractState.RevertToSnapshot(snapshot)
+			return []byte{}, err
+		}
+		return ret, nil
+	}
+
+	code, err := vm.contractState.GetCode(addr)
+	if err != nil {
+		// Calling an address without code only transfers the value.
+		return []byte{}, nil
+	}
+
+	frame := NewVM(code, vm.contractState, gas)
+	frame.address = addr
+	frame.caller = vm.address
+	frame.input = input
+	frame.depth = vm.depth + 1
+	frame.static = vm.static || static
+	frame.tracer = vm.tracer
+	// The frame appends to the caller's logs so the total is bounded by
+	// maxLogs; they are only kept if the call succeeds.
+	frame.logs = vm.logs
+
+	err = frame.Run()
+	vm.gasUsed += frame.GasUsed()
+
+	ret := frame.ReturnValue()
+	if ret == nil {
+		ret = []byte{}
+	}
+	if err != nil {
+		vm.contractState.RevertToSnapshot(snapshot)
+		return ret, err
+	}
+	vm.logs = frame.logs
+
+	return ret, nil
+}
+
+// runPrecompile runs p with a gas allowance of gas and charges vm for it.
+// Like a frame running out of gas, a precompile it cannot pay for uses up
+// the whole allowance.
+func (vm *VM) runPrecompile(p precompile, input []byte, gas uint64) ([]byte, error) {
+	cost := p.Gas(input)
+	if cost > gas {
+		vm.gasUsed += gas
+		return nil, ErrOutOfGas
+	}
+	vm.gasUsed += cost
+	return p.Run(input)
+}
+
+// allocBytes charges the gas for making a byte string of n bytes and checks
+// that a stack value can hold it.
+func (vm *VM) allocBytes(n int) error {
+	if n > maxValueSize {
+		return fmt.Errorf("%w: %d bytes, maximum is %d", ErrValueSize, n, maxValueSize)
+	}
+	return vm.useGas(uint64(n) * GasCopyByte)
+}
+
+// immediate decodes the value pushed by the push instruction at vm.pc.
+// InstrPushByte pushes its byte as a byte string of length one.
+func (vm *VM) immediate(instr Instruction) Value {
+	imm := vm.data[vm.pc+1 : vm.ip]
+	switch instr {
+	case InstrPushInt:
+		return IntValue(int64(imm[0]))
+	case InstrPushByte:
+		return BytesValue([]byte{imm[0]})
+	case InstrPushInt32:
+		return IntValue(int64(binary.LittleEndian.Uint32(imm)))
+	case InstrPushInt64:
+		return IntValue(int64(binary.LittleEndian.Uint64(imm)))
+	default:
+		return BytesValue(bytes.Clone(imm[4:]))
+	}
+}
+
+// jump moves execution to dest, which must hold an InstrJumpDest
+// instruction.
+func (vm *VM) jump(dest int64) error {
+	if dest < 0 || dest >= int64(len(vm.data)) || !vm.jumpdests[dest] {
+		return fmt.Errorf("%w: %d", ErrInvalidJump, dest)
+	}
+	vm.ip = int(dest)
+	return nil
+}
+
+func (vm *VM) popInt() (int64, error) {
+	v, err := vm.stack.Pop()
+	if err != nil {
+		return 0, err
+	}
+	n, ok := v.Int()
+	if !ok {
+		return 0, fmt.Errorf("%w: expected int, got bytes", ErrInvalidOperand)
+	}
+	return n, nil
+}
+
+func (vm *VM) popBytes() ([]byte, error) {
+	v, err := vm.stack.Pop()
+	if err != nil {
+		return nil, err
+	}
+	b, ok := v.Bytes()
+	if !ok {
+		return nil, fmt.Errorf("%w: expected bytes, got int", ErrInvalidOperand)
+	}
+	return b, nil
+}
+
+// popValue pops any stack value and returns its serialized form.
+func (vm *VM) popValue() ([]byte, error) {
+	v, err := vm.stack.Pop()
+	if err != nil {
+		return nil, err
+	}
+	return v.Serialize(), nil
+}
+
+// arithmetic applies a binary integer instruction. c is the value that was
+// on top of the stack.
+func arithmetic(instr Instruction, c, d int64) (int64, error) {
+	switch instr {
 	case InstrAdd:
-		a := vm.stack.Pop().(int) + vm.stack.Pop().(int)
-		vm.stack.Push(a)
+		return c + d, nil
 	case InstrSub:
-		c := vm.stack.Pop().(int)
-		d := vm.stack.Pop().(int)
-		a := c - d
-		vm.stack.Push(a)
+		return c - d, nil
 	case InstrMul:
-		a := vm.stack.Pop().(int) * vm.stack.Pop().(int)
-		vm.stack.Push(a)
+		return c * d, nil
 	case InstrDiv:
-		c := vm.stack.Pop().(int)
-		d := vm.stack.Pop().(int)
-		a := c / d
-		vm.stack.Push(a)
+		if d == 0 {
+			return 0, ErrDivisionByZero
+		}
+		return c / d, nil
+	case InstrLt:
+		return boolToInt(c < d), nil
+	case InstrGt:
+		return boolToInt(c > d), nil
+	case InstrAnd:
+		return boolToInt(c != 0 && d != 0), nil
+	case InstrOr:
+		return boolToInt(c != 0 || d != 0), nil
+	default:
+		return 0, ErrInvalidOpcode
 	}
+}
 
-	return nil
+func boolToInt(b bool) int64 {
+	if b {
+		return 1
+	}
+	return 0
 }
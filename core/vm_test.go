@@ -1,30 +1,436 @@
 package core
 
 import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"math"
 	"testing"
 
+	"github.com/3ssalunke/go-blockchain/types"
+	"github.com/3ssalunke/go-blockchain/util"
 	"github.com/stretchr/testify/assert"
 )
 
 func TestVM(t *testing.T) {
 	state := NewState()
-	data := []byte{0x01, 0x0a, 0x03, 0x0a, 0x0b}
-	vm := NewVM(data, state)
+	data := []byte{0x0a, 0x01, 0x0a, 0x03, 0x30}
+	vm := NewVM(data, state, 1000)
 
 	assert.Nil(t, vm.Run())
-	assert.Equal(t, 4, vm.stack.Pop())
+	assert.Equal(t, 4, top(t, vm))
 
-	data = []byte{0x07, 0x0a, 0x03, 0x0a, 0x0e}
-	vm = NewVM(data, state)
+	data = []byte{0x0a, 0x03, 0x0a, 0x07, 0x32}
+	vm = NewVM(data, state, 1000)
 
 	assert.Nil(t, vm.Run())
-	assert.Equal(t, 4, vm.stack.Pop())
+	assert.Equal(t, 4, top(t, vm))
 }
 
 func TestVMStoreInstr(t *testing.T) {
 	state := NewState()
-	data := []byte{0x03, 0x0a, 0x46, 0x0c, 0x4f, 0x0c, 0x4f, 0x0c, 0x0d, 0x01, 0x0a, 0x03, 0x0a, 0x0b}
-	vm := NewVM(data, state)
+	data := []byte{0x0a, 0x03, 0x0e, 0x03, 0x00, 0x00, 0x00, 0x46, 0x4f, 0x4f, 0x20}
+	vm := NewVM(data, state, 1000)
 
 	assert.Nil(t, vm.Run())
+
+	value, err := state.GetStorage(types.Address{}, []byte("FOO"))
+	assert.Nil(t, err)
+	assert.Equal(t, util.SerializeInt64(3), value)
+}
+
+// top pops the top of the stack as an int or a []byte, so tests can compare
+// it against plain literals.
+func top(t *testing.T, vm *VM) any {
+	v, err := vm.stack.Pop()
+	assert.Nil(t, err)
+	if n, ok := v.Int(); ok {
+		return int(n)
+	}
+	b, _ := v.Bytes()
+	return b
+}
+
+func TestVMOutOfGas(t *testing.T) {
+	data := program(pushInt(1), pushInt(3), InstrAdd)
+
+	vm := NewVM(data, NewState(), 100)
+	assert.Nil(t, vm.Run())
+	assert.Equal(t, uint64(2*GasQuick+GasFast), vm.GasUsed())
+
+	vm = NewVM(data, NewState(), 2*GasQuick)
+	assert.ErrorIs(t, vm.Run(), ErrOutOfGas)
+	assert.Equal(t, 2*GasQuick, vm.GasUsed())
+}
+
+func pushInt(n byte) []byte {
+	return []byte{byte(InstrPushInt), n}
+}
+
+func pushBytes(b []byte) []byte {
+	code := binary.LittleEndian.AppendUint32([]byte{byte(InstrPushBytes)}, uint32(len(b)))
+	return append(code, b...)
+}
+
+func program(parts ...any) []byte {
+	code := []byte{}
+	for _, part := range parts {
+		switch p := part.(type) {
+		case Instruction:
+			code = append(code, byte(p))
+		case []byte:
+			code = append(code, p...)
+		}
+	}
+	return code
+}
+
+func TestVMOpcodes(t *testing.T) {
+	tests := []struct {
+		name string
+		code []byte
+		want any
+	}{
+		{"mul", program(pushInt(3), pushInt(4), InstrMul), 12},
+		{"div", program(pushInt(2), pushInt(8), InstrDiv), 4},
+		{"eq int", program(pushInt(3), pushInt(3), InstrEq), 1},
+		{"eq bytes", program(pushBytes([]byte("ab")), pushBytes([]byte("ac")), InstrEq), 0},
+		{"lt", program(pushInt(5), pushInt(3), InstrLt), 1},
+		{"gt", program(pushInt(5), pushInt(3), InstrGt), 0},
+		{"and", program(pushInt(1), pushInt(0), InstrAnd), 0},
+		{"or", program(pushInt(1), pushInt(0), InstrOr), 1},
+		{"not", program(pushInt(0), InstrNot), 1},
+		{"pop", program(pushInt(1), pushInt(2), InstrPop), 1},
+		{"dup", program(pushInt(2), InstrDup, InstrAdd), 4},
+		{"swap", program(pushInt(1), pushInt(3), InstrSwap, InstrSub), -2},
+		{"concat", program(pushBytes([]byte("cd")), pushBytes([]byte("ab")), InstrConcat), []byte("abcd")},
+		{"slice", program(pushInt(3), pushInt(1), pushBytes([]byte("abcd")), InstrSlice), []byte("bc")},
+		{"len", program(pushBytes([]byte("abc")), InstrLen), 3},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			vm := NewVM(tt.code, NewState(), 1000)
+			assert.Nil(t, vm.Run())
+			assert.Equal(t, tt.want, top(t, vm))
+		})
+	}
+}
+
+func TestVMOpcodeErrors(t *testing.T) {
+	overflow := []byte{}
+	for i := 0; i <= maxStackDepth; i++ {
+		overflow = append(overflow, pushInt(1)...)
+	}
+
+	tests := []struct {
+		name string
+		code []byte
+		want error
+	}{
+		{"div by zero", program(pushInt(0), pushInt(8), InstrDiv), ErrDivisionByZero},
+		{"underflow", program(pushInt(1), InstrAdd), ErrStackUnderflow},
+		{"overflow", overflow, ErrStackOverflow},
+		{"type mismatch", program(pushInt(1), InstrLen), ErrInvalidOperand},
+		{"store type mismatch", program(pushInt(1), pushInt(1), InstrStore), ErrInvalidOperand},
+		{"pack type mismatch", program(pushInt(1), pushInt(1), InstrPack), ErrInvalidOperand},
+		{"truncated push", program(InstrPushInt), ErrInvalidCode},
+		{"truncated push bytes", program(InstrPushBytes, []byte{3, 0, 0, 0, 'a', 'b'}), ErrInvalidCode},
+		{"slice out of range", program(pushInt(5), pushInt(0), pushBytes([]byte("ab")), InstrSlice), ErrInvalidOperand},
+		{"invalid opcode", []byte{0xff}, ErrInvalidOpcode},
+		{"invalid jump destination", program(pushInt(1), InstrJump), ErrInvalidJump},
+		{"jump into immediate", program(pushInt(3), InstrJump, pushInt(byte(InstrJumpDest))), ErrInvalidJump},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := NewVM(tt.code, NewState(), 1000).Run()
+			assert.ErrorIs(t, err, tt.want)
+
+			var vmErr *VMError
+			assert.ErrorAs(t, err, &vmErr)
+		})
+	}
+}
+
+func TestVMStoreGetDelete(t *testing.T) {
+	state := NewState()
+	key := []byte("k")
+
+	vm := NewVM(program(pushInt(7), pushBytes(key), InstrStore, pushBytes(key), InstrGet), state, 1000)
+	assert.Nil(t, vm.Run())
+	assert.Equal(t, util.SerializeInt64(7), top(t, vm))
+
+	vm = NewVM(program(pushBytes(key), InstrDelete, pushBytes(key), InstrGet), state, 1000)
+	assert.Nil(t, vm.Run())
+	assert.Equal(t, []byte{}, top(t, vm))
+	_, err := state.GetStorage(types.Address{}, key)
+	assert.NotNil(t, err)
+}
+
+func TestVMStoreGas(t *testing.T) {
+	key := []byte("k")
+	value := bytes.Repeat([]byte{'v'}, 100)
+
+	vm := NewVM(program(pushBytes(value), pushBytes(key), InstrStore), NewState(), 10000)
+	assert.Nil(t, vm.Run())
+	assert.Equal(t, 2*GasFast+101*GasCopyByte+GasStore+101*GasStoreByte, vm.GasUsed())
+
+	// Without the gas for every byte nothing is written.
+	state := NewState()
+	vm = NewVM(program(pushBytes(value), pushBytes(key), InstrStore), state, 2*GasFast+101*GasCopyByte+GasStore+100*GasStoreByte)
+	assert.ErrorIs(t, vm.Run(), ErrOutOfGas)
+	_, err := state.GetStorage(types.Address{}, key)
+	assert.NotNil(t, err)
+}
+
+func TestVMValueSize(t *testing.T) {
+	// Doubles a byte string for as long as there is gas.
+	loop := program(pushBytes([]byte("a")), InstrJumpDest, InstrDup, InstrConcat, pushInt(6), InstrJump)
+
+	vm := NewVM(loop, NewState(), DefaultTxGasLimit)
+	assert.ErrorIs(t, vm.Run(), ErrOutOfGas)
+
+	vm = NewVM(loop, NewState(), DefaultBlockGasLimit)
+	assert.ErrorIs(t, vm.Run(), ErrValueSize)
+
+	// PACK is charged for the bytes it copies too.
+	code := program(pushBytes([]byte("ab")), pushBytes([]byte("cd")), pushInt(2), InstrPack)
+	vm = NewVM(code, NewState(), 1000)
+	assert.Nil(t, vm.Run())
+	assert.Equal(t, []byte("cdab"), top(t, vm))
+	assert.Equal(t, 3*GasFast+GasQuick+8*GasCopyByte, vm.GasUsed())
+}
+
+func TestVMJump(t *testing.T) {
+	// The jump skips pushing 1 and lands on the InstrJumpDest at index 5.
+	vm := NewVM(program(pushInt(5), InstrJump, pushInt(1), InstrJumpDest, pushInt(2)), NewState(), 1000)
+	assert.Nil(t, vm.Run())
+	assert.Equal(t, 1, vm.stack.Len())
+	assert.Equal(t, 2, top(t, vm))
+
+	for cond, depth := range []int{2, 1} {
+		code := program(pushInt(byte(cond)), pushInt(7), InstrJumpI, pushInt(1), InstrJumpDest, pushInt(2))
+		vm = NewVM(code, NewState(), 1000)
+		assert.Nil(t, vm.Run())
+		assert.Equal(t, depth, vm.stack.Len())
+	}
+
+	// An endless loop is stopped by the gas limit.
+	vm = NewVM(program(InstrJumpDest, pushInt(0), InstrJump), NewState(), 1000)
+	assert.ErrorIs(t, vm.Run(), ErrOutOfGas)
+}
+
+func TestVMReturnRevert(t *testing.T) {
+	vm := NewVM(program(pushBytes([]byte("ok")), InstrReturn, pushInt(1)), NewState(), 1000)
+	assert.Nil(t, vm.Run())
+	assert.Equal(t, []byte("ok"), vm.ReturnValue())
+	assert.Equal(t, 0, vm.stack.Len())
+
+	state := NewState()
+	vm = NewVM(program(pushInt(1), pushBytes([]byte("k")), InstrStore, pushBytes([]byte("no")), InstrRevert), state, 1000)
+	assert.ErrorIs(t, vm.Run(), ErrReverted)
+	assert.Equal(t, []byte("no"), vm.ReturnValue())
+}
+
+func TestVMLog(t *testing.T) {
+	code := program(pushBytes([]byte("data")), pushBytes([]byte("b")), pushBytes([]byte("a")), pushInt(2), InstrLog)
+	vm := NewVM(code, NewState(), 1000)
+	vm.address = types.AddressFromBytes(bytes.Repeat([]byte{0x01}, 20))
+	assert.Nil(t, vm.Run())
+
+	assert.Len(t, vm.Logs(), 1)
+	l := vm.Logs()[0]
+	assert.Equal(t, vm.address, l.Address)
+	assert.Equal(t, []types.Hash{sha256.Sum256([]byte("a")), sha256.Sum256([]byte("b"))}, l.Topics)
+	assert.Equal(t, []byte("data"), l.Data)
+
+	code = program(pushBytes([]byte("data")), pushInt(maxLogTopics+1), InstrLog)
+	assert.ErrorIs(t, NewVM(code, NewState(), 1000).Run(), ErrInvalidOperand)
+}
+
+// callProgram calls addr with input and a gas allowance of 10000.
+func callProgram(instr Instruction, addr types.Address, input []byte) []byte {
+	code := []byte{}
+	if instr == InstrCall {
+		code = pushInt(0)
+	}
+	return program(code, pushInt(250), pushInt(40), InstrMul, pushBytes(input), pushBytes(addr[:]), instr)
+}
+
+func deployCode(state *State, code []byte) types.Address {
+	addr := types.AddressFromBytes(types.RandomBytes(20))
+	state.PutCode(addr, code)
+	return addr
+}
+
+func TestVMCall(t *testing.T) {
+	state := NewState()
+	caller := types.AddressFromBytes(types.RandomBytes(20))
+	// The callee stores its input under "x" and returns "hi".
+	callee := deployCode(state, program(InstrCallData, pushBytes([]byte("x")), InstrStore, pushBytes([]byte("hi")), InstrReturn))
+
+	vm := NewVM(callProgram(InstrCall, callee, []byte("in")), state, 100_000)
+	vm.address = caller
+	assert.Nil(t, vm.Run())
+	assert.Equal(t, 1, top(t, vm))
+	assert.Equal(t, []byte("hi"), top(t, vm))
+	assert.Greater(t, vm.GasUsed(), GasCall+GasStore)
+
+	value, err := state.GetStorage(callee, []byte("x"))
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("in"), value)
+	_, err = state.GetStorage(caller, []byte("x"))
+	assert.NotNil(t, err)
+}
+
+func TestVMCallRevert(t *testing.T) {
+	state := NewState()
+	callee := deployCode(state, program(pushInt(1), pushBytes([]byte("x")), InstrStore, pushBytes([]byte("no")), InstrRevert))
+
+	// The caller keeps running after the callee reverts.
+	vm := NewVM(program(callProgram(InstrCall, callee, nil), pushInt(7)), state, 100_000)
+	assert.Nil(t, vm.Run())
+	assert.Equal(t, 7, top(t, vm))
+	assert.Equal(t, 0, top(t, vm))
+	assert.Equal(t, []byte("no"), top(t, vm))
+
+	_, err := state.GetStorage(callee, []byte("x"))
+	assert.NotNil(t, err)
+}
+
+func TestVMCallValueOverflow(t *testing.T) {
+	state := NewState()
+	caller := types.AddressFromBytes(types.RandomBytes(20))
+	callee := deployCode(state, program(InstrJumpDest))
+	assert.Nil(t, state.PutAccount(caller, &Account{Balance: 10}))
+	assert.Nil(t, state.PutAccount(callee, &Account{Balance: math.MaxUint64}))
+
+	// Crediting the callee fails after the caller was debited.
+	code := program(pushInt(5), pushInt(250), pushInt(40), InstrMul, pushBytes(nil), pushBytes(callee[:]), InstrCall)
+	vm := NewVM(code, state, 100_000)
+	vm.address = caller
+	assert.Nil(t, vm.Run())
+	assert.Equal(t, 0, top(t, vm))
+
+	acc, err := state.GetAccount(caller)
+	assert.Nil(t, err)
+	assert.Equal(t, uint64(10), acc.Balance)
+}
+
+func TestVMStaticCall(t *testing.T) {
+	state := NewState()
+	writer := deployCode(state, program(pushInt(1), pushBytes([]byte("x")), InstrStore))
+	reader := deployCode(state, program(pushBytes([]byte("x")), InstrGet, InstrReturn))
+	// A static call stays static through the calls it makes.
+	proxy := deployCode(state, callProgram(InstrCall, writer, nil))
+
+	for addr, want := range map[types.Address]int{writer: 0, reader: 1, proxy: 1} {
+		vm := NewVM(callProgram(InstrStaticCall, addr, nil), state, 100_000)
+		assert.Nil(t, vm.Run())
+		assert.Equal(t, want, top(t, vm))
+	}
+
+	_, err := state.GetStorage(writer, []byte("x"))
+	assert.NotNil(t, err)
+}
+
+func TestVMCallDepth(t *testing.T) {
+	state := NewState()
+	callee := deployCode(state, program(pushBytes([]byte("ok")), InstrReturn))
+
+	vm := NewVM(callProgram(InstrCall, callee, nil), state, 100_000)
+	vm.depth = maxCallDepth
+	assert.Nil(t, vm.Run())
+	assert.Equal(t, 0, top(t, vm))
+
+	// A contract calling itself recurses until the depth limit.
+	self := types.AddressFromBytes(types.RandomBytes(20))
+	state.PutCode(self, callProgram(InstrCall, self, nil))
+	vm = NewVM(callProgram(InstrCall, self, nil), state, 10_000_000)
+	assert.Nil(t, vm.Run())
+	assert.Equal(t, 1, top(t, vm))
+}
+
+func TestVMPushWidths(t *testing.T) {
+	tests := []struct {
+		name string
+		code []byte
+		want any
+	}{
+		{"int", program(InstrPushInt, []byte{0xff}), 255},
+		{"byte", program(InstrPushByte, []byte{0xff}), []byte{0xff}},
+		{"int32", program(InstrPushInt32, []byte{0x70, 0x11, 0x01, 0x00}), 70000},
+		{"int64", program(InstrPushInt64, bytes.Repeat([]byte{0xff}, 8)), -1},
+		{"bytes", pushBytes([]byte("abc")), []byte("abc")},
+		{"empty bytes", pushBytes(nil), []byte{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			vm := NewVM(tt.code, NewState(), 1000)
+			assert.Nil(t, vm.Run())
+			assert.Equal(t, tt.want, top(t, vm))
+		})
+	}
+}
+
+func TestValidateCode(t *testing.T) {
+	assert.Nil(t, ValidateCode(nil))
+	assert.Nil(t, ValidateCode(program(pushBytes([]byte{0xff}), InstrPushInt64, make([]byte, 8))))
+
+	assert.ErrorIs(t, ValidateCode([]byte{0xff}), ErrInvalidOpcode)
+	assert.ErrorIs(t, ValidateCode(program(InstrPushInt32, []byte{1, 2})), ErrInvalidCode)
+	assert.ErrorIs(t, ValidateCode(program(InstrPushBytes, []byte{0xff, 0xff, 0xff, 0xff})), ErrInvalidCode)
+
+	// A truncated program does not run at all, so nothing is stored.
+	state := NewState()
+	err := NewVM(program(pushInt(1), pushBytes([]byte("k")), InstrStore, InstrPushInt), state, 1000).Run()
+	assert.ErrorIs(t, err, ErrInvalidCode)
+	_, err = state.GetStorage(types.Address{}, []byte("k"))
+	assert.NotNil(t, err)
+}
+
+// benchLoop counts down from 1000, so a run executes about 10,000
+// instructions of stack manipulation, arithmetic and jumps.
+const benchLoop = `
+	PUSH 1000
+loop:	JUMPDEST
+	PUSH 1
+	SWAP
+	SUB
+	DUP
+	PUSH @loop
+	JUMPI
+`
+
+func BenchmarkVMLoop(b *testing.B) {
+	code, err := Assemble(benchLoop)
+	if err != nil {
+		b.Fatal(err)
+	}
+	state := NewState()
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		vm := NewVM(code, state, 1_000_000)
+		if err := vm.Run(); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkVMStore(b *testing.B) {
+	code := storeProgram('k', 7)
+	state := NewState()
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		vm := NewVM(code, state, 1_000_000)
+		if err := vm.Run(); err != nil {
+			b.Fatal(err)
+		}
+	}
 }
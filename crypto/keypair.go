@@ -30,8 +30,12 @@ func (k PrivateKey) PublicKey() PublicKey {
 	return elliptic.MarshalCompressed(k.key.PublicKey, k.key.PublicKey.X, k.key.PublicKey.Y)
 }
 
+// Sign signs the sha256 digest of data. ECDSA only uses as many bytes of
+// its input as the curve order is long, so signing data directly would leave
+// everything past the first 32 bytes unsigned.
 func (k PrivateKey) Sign(data []byte) (*Signature, error) {
-	r, s, err := ecdsa.Sign(rand.Reader, k.key, data)
+	digest := sha256.Sum256(data)
+	r, s, err := ecdsa.Sign(rand.Reader, k.key, digest[:])
 	if err != nil {
 		return nil, err
 	}
@@ -56,12 +60,17 @@ func (sig Signature) String() string {
 	return hex.EncodeToString(b)
 }
 
+// Verify checks the signature against the sha256 digest of data.
 func (sig Signature) Verify(pubKey PublicKey, data []byte) bool {
 	x, y := elliptic.UnmarshalCompressed(elliptic.P256(), pubKey)
+	if x == nil {
+		return false
+	}
 	key := &ecdsa.PublicKey{
 		Curve: elliptic.P256(),
 		X:     x,
 		Y:     y,
 	}
-	return ecdsa.Verify(key, data, sig.R, sig.S)
+	digest := sha256.Sum256(data)
+	return ecdsa.Verify(key, digest[:], sig.R, sig.S)
 }
@@ -23,3 +23,16 @@ func TestKeyPairSignVerify(t *testing.T) {
 	otherMsg := []byte("hello world again")
 	assert.False(t, sig.Verify(pubKey, otherMsg))
 }
+
+func TestSignCoversLongMessages(t *testing.T) {
+	privKey := GeneratePrivateKey()
+	pubKey := privKey.PublicKey()
+
+	msg := make([]byte, 64)
+	sig, err := privKey.Sign(msg)
+	assert.Nil(t, err)
+	assert.True(t, sig.Verify(pubKey, msg))
+
+	msg[63] = 0x01
+	assert.False(t, sig.Verify(pubKey, msg))
+}
@@ -2,16 +2,47 @@ package main
 
 import (
 	"bytes"
+	"encoding/hex"
+	"fmt"
 	"log"
 	"net"
+	"os"
 	"time"
 
 	"github.com/3ssalunke/go-blockchain/core"
 	"github.com/3ssalunke/go-blockchain/crypto"
 	"github.com/3ssalunke/go-blockchain/network"
+	"github.com/3ssalunke/go-blockchain/types"
 )
 
+const chainID = 1
+
+// faucetAddress is the account funded in the genesis block.
+const faucetAddress = "8a6f2c1d0b9e4f3a7c5d2e1f0a9b8c7d6e5f4a3b"
+
+// genesisAlloc is the allocation every node starts from. Nodes only connect
+// to peers with the same genesis, so it must be the same on all of them.
+var genesisAlloc = core.GenesisAlloc{
+	mustDecodeAddress(faucetAddress): 1_000_000,
+}
+
+func mustDecodeAddress(s string) types.Address {
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		panic(err)
+	}
+	return types.AddressFromBytes(b)
+}
+
 func main() {
+	if len(os.Args) > 1 {
+		if err := runCommand(os.Args[1:]); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+
 	pk := crypto.GeneratePrivateKey()
 	localNode := makeServer("localNode", &pk, ":3000", ":8080")
 
@@ -31,17 +62,40 @@ func tcpTester() {
 		panic(err)
 	}
 
+	// The node only accepts messages after a handshake. Answer its own
+	// handshake under a different node ID.
+	frame, err := network.ReadFrame(conn)
+	if err != nil {
+		panic(err)
+	}
+	hsMsg := network.Message{}
+	if err := hsMsg.Decode(bytes.NewReader(frame)); err != nil {
+		panic(err)
+	}
+	hs := new(network.HandshakeMessage)
+	if err := hs.Decode(hsMsg.Data); err != nil {
+		panic(err)
+	}
+	hs.NodeID = "tcp-tester"
+	hs.ListenAddr = ""
+	if err := network.WriteFrame(conn, network.NewMessage(network.MessageTypeHandshake, hs.Bytes()).Bytes()); err != nil {
+		panic(err)
+	}
+
 	privKey := crypto.GeneratePrivateKey()
-	data := []byte{0x01, 0x0a, 0x03, 0x0a, 0x0b}
+	data, err := core.Assemble("PUSH 1\nPUSH 3\nADD")
+	if err != nil {
+		panic(err)
+	}
 	tx := core.NewTransaction(data)
+	tx.ChainID = chainID
 	tx.Sign(privKey)
 	buf := &bytes.Buffer{}
-	if err := tx.Encode(core.NewGobTxEncoder(buf)); err != nil {
+	if err := tx.Encode(core.NewBinaryTxEncoder(buf)); err != nil {
 		panic(err)
 	}
 	msg := network.NewMessage(network.MessageTypeTx, buf.Bytes())
-	_, err = conn.Write(msg.Bytes())
-	if err != nil {
+	if err := network.WriteFrame(conn, msg.Bytes()); err != nil {
 		panic(err)
 	}
 }
@@ -53,6 +107,9 @@ func makeServer(id string, pk *crypto.PrivateKey, addr string, apiListenAddr str
 		ID:            id,
 		PrivateKey:    pk,
 		BlockTime:     5 * time.Second,
+		ChainID:       chainID,
+		DataDir:       "./data/" + id,
+		GenesisAlloc:  genesisAlloc,
 	}
 	s, err := network.NewServer(opts)
 	if err != nil {
@@ -96,7 +153,7 @@ func makeServer(id string, pk *crypto.PrivateKey, addr string, apiListenAddr str
 // 	tx := core.NewTransaction(data)
 // 	tx.Sign(privKey)
 // 	buf := &bytes.Buffer{}
-// 	if err := tx.Encode(core.NewGobTxEncoder(buf)); err != nil {
+// 	if err := tx.Encode(core.NewBinaryTxEncoder(buf)); err != nil {
 // 		return err
 // 	}
 // 	msg := network.NewMessage(network.MessageTypeTx, buf.Bytes())
@@ -0,0 +1,152 @@
+package network
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/3ssalunke/go-blockchain/core"
+	"github.com/3ssalunke/go-blockchain/util"
+)
+
+// maxBlocksPerMessage bounds the block count read from a BlocksMessage.
+const maxBlocksPerMessage = 1 << 12
+
+// blocksMessageOverhead is more than a BlocksMessage sent as a Message takes
+// besides its blocks, and blockOverhead more than each block takes besides
+// its encoding.
+const (
+	blocksMessageOverhead = 64
+	blockOverhead         = 8
+)
+
+func (m *GetBlockMessage) Bytes() []byte {
+	w := newMessageWriter()
+	w.WriteUint32(m.From)
+	w.WriteUint32(m.To)
+	return w.Bytes()
+}
+
+func (m *GetBlockMessage) Decode(data []byte) error {
+	r, err := newMessageReader(data)
+	if err != nil {
+		return err
+	}
+	m.From = r.ReadUint32()
+	m.To = r.ReadUint32()
+	return r.Err()
+}
+
+func (m *BlocksMessage) Bytes() ([]byte, error) {
+	w := newMessageWriter()
+	w.WriteUint32(uint32(len(m.Blocks)))
+	for _, b := range m.Blocks {
+		buf := &bytes.Buffer{}
+		if err := b.Encode(core.NewBinaryBlockEncoder(buf)); err != nil {
+			return nil, err
+		}
+		w.WriteBytes(buf.Bytes())
+	}
+	return w.Bytes(), nil
+}
+
+// encodedBlockSize returns an upper bound of the bytes b takes in a
+// BlocksMessage.
+func encodedBlockSize(b *core.Block) (int, error) {
+	buf := &bytes.Buffer{}
+	if err := b.Encode(core.NewBinaryBlockEncoder(buf)); err != nil {
+		return 0, err
+	}
+	return buf.Len() + blockOverhead, nil
+}
+
+func (m *BlocksMessage) Decode(data []byte) error {
+	r, err := newMessageReader(data)
+	if err != nil {
+		return err
+	}
+
+	n := r.ReadUint32()
+	if n > maxBlocksPerMessage {
+		return fmt.Errorf("blocks message has %d blocks, maximum is %d", n, maxBlocksPerMessage)
+	}
+
+	m.Blocks = make([]*core.Block, 0, n)
+	for i := uint32(0); i < n; i++ {
+		data := r.ReadBytes()
+		if err := r.Err(); err != nil {
+			return err
+		}
+		b := new(core.Block)
+		if err := b.Decode(core.NewBinaryBlockDecoder(bytes.NewReader(data))); err != nil {
+			return err
+		}
+		m.Blocks = append(m.Blocks, b)
+	}
+
+	return r.Err()
+}
+
+func (m *GetStatusMessage) Bytes() []byte {
+	return newMessageWriter().Bytes()
+}
+
+func (m *GetStatusMessage) Decode(data []byte) error {
+	_, err := newMessageReader(data)
+	return err
+}
+
+func (m *StatusMessage) Bytes() []byte {
+	w := newMessageWriter()
+	w.WriteString(m.ID)
+	w.WriteUint32(m.CurrentHeight)
+	w.WriteUint32(m.Version)
+	return w.Bytes()
+}
+
+func (m *StatusMessage) Decode(data []byte) error {
+	r, err := newMessageReader(data)
+	if err != nil {
+		return err
+	}
+	m.ID = r.ReadString()
+	m.CurrentHeight = r.ReadUint32()
+	m.Version = r.ReadUint32()
+	return r.Err()
+}
+
+func (m *HandshakeMessage) Bytes() []byte {
+	w := newMessageWriter()
+	w.WriteUint32(m.Version)
+	w.WriteUint32(m.ChainID)
+	w.WriteFixed(m.GenesisHash[:])
+	w.WriteString(m.NodeID)
+	w.WriteString(m.ListenAddr)
+	return w.Bytes()
+}
+
+func (m *HandshakeMessage) Decode(data []byte) error {
+	r, err := newMessageReader(data)
+	if err != nil {
+		return err
+	}
+	m.Version = r.ReadUint32()
+	m.ChainID = r.ReadUint32()
+	copy(m.GenesisHash[:], r.ReadFixed(32))
+	m.NodeID = r.ReadString()
+	m.ListenAddr = r.ReadString()
+	return r.Err()
+}
+
+func newMessageWriter() *util.BinaryWriter {
+	w := util.NewBinaryWriter()
+	w.WriteUint8(core.CodecVersion)
+	return w
+}
+
+func newMessageReader(data []byte) (*util.BinaryReader, error) {
+	r := util.NewBinaryReader(bytes.NewReader(data))
+	if version := r.ReadUint8(); r.Err() == nil && version != core.CodecVersion {
+		return nil, fmt.Errorf("unsupported codec version %d", version)
+	}
+	return r, r.Err()
+}
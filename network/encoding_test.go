@@ -0,0 +1,65 @@
+package network
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/3ssalunke/go-blockchain/core"
+	"github.com/3ssalunke/go-blockchain/crypto"
+	"github.com/3ssalunke/go-blockchain/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMessageEncodeDecode(t *testing.T) {
+	status := &StatusMessage{ID: "node", CurrentHeight: 12, Version: 1}
+	msg := NewMessage(MessageTypeStatus, status.Bytes())
+
+	decoded, err := DefaultRPCDecoderFunc(RPC{Payload: bytes.NewReader(msg.Bytes())})
+	assert.Nil(t, err)
+	assert.Equal(t, status, decoded.Data)
+
+	getBlocks := &GetBlockMessage{From: 3, To: 9}
+	msg = NewMessage(MessageTypeGetBlocks, getBlocks.Bytes())
+
+	decoded, err = DefaultRPCDecoderFunc(RPC{Payload: bytes.NewReader(msg.Bytes())})
+	assert.Nil(t, err)
+	assert.Equal(t, getBlocks, decoded.Data)
+}
+
+func TestHandshakeMessageEncodeDecode(t *testing.T) {
+	hs := &HandshakeMessage{
+		Version:     ProtocolVersion,
+		ChainID:     7,
+		GenesisHash: types.HashFromBytes(types.RandomBytes(32)),
+		NodeID:      "node",
+		ListenAddr:  "127.0.0.1:3000",
+	}
+
+	decoded := new(HandshakeMessage)
+	assert.Nil(t, decoded.Decode(hs.Bytes()))
+	assert.Equal(t, hs, decoded)
+}
+
+func TestBlocksMessageEncodeDecode(t *testing.T) {
+	genesis, err := core.GenesisBlock(core.GenesisAlloc{
+		crypto.GeneratePrivateKey().PublicKey().Address(): 100,
+	})
+	assert.Nil(t, err)
+
+	blocks := &BlocksMessage{Blocks: []*core.Block{genesis, genesis}}
+	data, err := blocks.Bytes()
+	assert.Nil(t, err)
+
+	decoded, err := DefaultRPCDecoderFunc(RPC{Payload: bytes.NewReader(NewMessage(MessageTypeBlocks, data).Bytes())})
+	assert.Nil(t, err)
+
+	msg := decoded.Data.(*BlocksMessage)
+	assert.Equal(t, 2, len(msg.Blocks))
+	for _, b := range msg.Blocks {
+		assert.Equal(t, genesis.Hash(core.BlockHasher{}), b.Hash(core.BlockHasher{}))
+		assert.Equal(t, genesis.DataHash, b.DataHash)
+		calculated, err := core.CalculateDataHash(b.Transactions)
+		assert.Nil(t, err)
+		assert.Equal(t, genesis.DataHash, calculated)
+	}
+}
@@ -1,6 +1,13 @@
 package network
 
-import "github.com/3ssalunke/go-blockchain/core"
+import (
+	"github.com/3ssalunke/go-blockchain/core"
+	"github.com/3ssalunke/go-blockchain/types"
+)
+
+// ProtocolVersion is the version of the peer to peer protocol. Peers
+// speaking another version are disconnected during the handshake.
+const ProtocolVersion uint32 = 1
 
 type GetBlockMessage struct {
 	From uint32
@@ -18,3 +25,16 @@ type StatusMessage struct {
 	CurrentHeight uint32
 	Version       uint32
 }
+
+// HandshakeMessage is the first message both ends of a connection send.
+// No other message is accepted from a peer until its handshake checked
+// out.
+type HandshakeMessage struct {
+	Version     uint32
+	ChainID     uint32
+	GenesisHash types.Hash
+	NodeID      string
+	// ListenAddr is the address the node accepts connections on, which
+	// differs from the address of an outbound connection it made.
+	ListenAddr string
+}
@@ -2,11 +2,11 @@ package network
 
 import (
 	"bytes"
-	"encoding/gob"
 	"fmt"
 	"io"
 
 	"github.com/3ssalunke/go-blockchain/core"
+	"github.com/3ssalunke/go-blockchain/util"
 )
 
 type MessageType byte
@@ -18,6 +18,7 @@ const (
 	MessageTypeStatus    MessageType = 0x4
 	MessageTypeGetStatus MessageType = 0x5
 	MessageTypeBlocks    MessageType = 0x6
+	MessageTypeHandshake MessageType = 0x7
 )
 
 type RPC struct {
@@ -38,9 +39,21 @@ func NewMessage(t MessageType, data []byte) *Message {
 }
 
 func (msg *Message) Bytes() []byte {
-	buf := &bytes.Buffer{}
-	gob.NewEncoder(buf).Encode(msg)
-	return buf.Bytes()
+	w := util.NewBinaryWriter()
+	w.WriteUint8(core.CodecVersion)
+	w.WriteUint8(uint8(msg.Header))
+	w.WriteBytes(msg.Data)
+	return w.Bytes()
+}
+
+func (msg *Message) Decode(r io.Reader) error {
+	br := util.NewBinaryReader(r)
+	if version := br.ReadUint8(); br.Err() == nil && version != core.CodecVersion {
+		return fmt.Errorf("unsupported codec version %d", version)
+	}
+	msg.Header = MessageType(br.ReadUint8())
+	msg.Data = br.ReadBytes()
+	return br.Err()
 }
 
 type DecodedMessage struct {
@@ -52,14 +65,14 @@ type RPCDecodeFunc func(RPC) (*DecodedMessage, error)
 
 func DefaultRPCDecoderFunc(rpc RPC) (*DecodedMessage, error) {
 	msg := Message{}
-	if err := gob.NewDecoder(rpc.Payload).Decode(&msg); err != nil {
+	if err := msg.Decode(rpc.Payload); err != nil {
 		return nil, fmt.Errorf("failed to decode message from %s: %s", rpc.From, err)
 	}
 
 	switch msg.Header {
 	case MessageTypeTx:
 		tx := new(core.Transaction)
-		if err := tx.Decode(core.NewGobTxDecoder(bytes.NewReader(msg.Data))); err != nil {
+		if err := tx.Decode(core.NewBinaryTxDecoder(bytes.NewReader(msg.Data))); err != nil {
 			return nil, err
 		}
 		return &DecodedMessage{
@@ -68,7 +81,7 @@ func DefaultRPCDecoderFunc(rpc RPC) (*DecodedMessage, error) {
 		}, nil
 	case MessageTypeBlock:
 		block := new(core.Block)
-		if err := block.Decode(core.NewGobBlockDecoder(bytes.NewBuffer(msg.Data))); err != nil {
+		if err := block.Decode(core.NewBinaryBlockDecoder(bytes.NewReader(msg.Data))); err != nil {
 			return nil, err
 		}
 		return &DecodedMessage{
@@ -77,7 +90,7 @@ func DefaultRPCDecoderFunc(rpc RPC) (*DecodedMessage, error) {
 		}, nil
 	case MessageTypeStatus:
 		statusMessage := new(StatusMessage)
-		if err := gob.NewDecoder(bytes.NewReader(msg.Data)).Decode(statusMessage); err != nil {
+		if err := statusMessage.Decode(msg.Data); err != nil {
 			return nil, err
 		}
 		return &DecodedMessage{
@@ -85,13 +98,17 @@ func DefaultRPCDecoderFunc(rpc RPC) (*DecodedMessage, error) {
 			Data: statusMessage,
 		}, nil
 	case MessageTypeGetStatus:
+		getStatusMessage := new(GetStatusMessage)
+		if err := getStatusMessage.Decode(msg.Data); err != nil {
+			return nil, err
+		}
 		return &DecodedMessage{
 			From: rpc.From,
-			Data: nil,
+			Data: getStatusMessage,
 		}, nil
 	case MessageTypeGetBlocks:
 		getBlockMessage := new(GetBlockMessage)
-		if err := gob.NewDecoder(bytes.NewReader(msg.Data)).Decode(getBlockMessage); err != nil {
+		if err := getBlockMessage.Decode(msg.Data); err != nil {
 			return nil, err
 		}
 		return &DecodedMessage{
@@ -100,7 +117,7 @@ func DefaultRPCDecoderFunc(rpc RPC) (*DecodedMessage, error) {
 		}, nil
 	case MessageTypeBlocks:
 		blocksMessage := new(BlocksMessage)
-		if err := gob.NewDecoder(bytes.NewReader(msg.Data)).Decode(blocksMessage); err != nil {
+		if err := blocksMessage.Decode(msg.Data); err != nil {
 			return nil, err
 		}
 		return &DecodedMessage{
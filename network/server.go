@@ -2,7 +2,6 @@ package network
 
 import (
 	"bytes"
-	"encoding/gob"
 	"fmt"
 	"sync"
 	"time"
@@ -12,6 +11,22 @@ import (
 	"github.com/3ssalunke/go-blockchain/crypto"
 )
 
+// Defaults for the peer limits of ServerOpts.
+const (
+	DefaultMaxInboundPeers  = 32
+	DefaultMaxOutboundPeers = 8
+)
+
+// Delays between attempts to reach a seed node. The delay doubles after
+// every failed attempt up to maxDialBackoff.
+const (
+	minDialBackoff = time.Second
+	maxDialBackoff = time.Minute
+)
+
+// handshakeTimeout bounds how long a new peer has to send its handshake.
+const handshakeTimeout = 5 * time.Second
+
 type ServerOpts struct {
 	APIListenAddr string
 	ListenAddr    string
@@ -20,6 +35,21 @@ type ServerOpts struct {
 	RPCProcessor
 	BlockTime  time.Duration
 	PrivateKey *crypto.PrivateKey
+	// ChainID identifies the network the node belongs to.
+	ChainID uint32
+	// GenesisAlloc holds the initial account balances.
+	GenesisAlloc core.GenesisAlloc
+	// DataDir is where the node persists its blocks. When empty the chain
+	// is kept in memory only.
+	DataDir string
+	// SeedNodes are the addresses of nodes to connect to on start. The
+	// connections are kept up, reconnecting whenever one drops.
+	SeedNodes []string
+	// MaxInboundPeers and MaxOutboundPeers limit the connections accepted
+	// and dialed. They default to DefaultMaxInboundPeers and
+	// DefaultMaxOutboundPeers.
+	MaxInboundPeers  int
+	MaxOutboundPeers int
 }
 
 type Server struct {
@@ -31,24 +61,43 @@ type Server struct {
 	peerChan     chan *TCPPeer
 
 	peerMapMU sync.RWMutex
-	peerMap   map[NetAddr]*TCPPeer
+	// peerMap holds the connected peers by remote address.
+	peerMap map[string]*TCPPeer
 
 	rpcCh    chan RPC
 	quitChan chan struct{}
 	txCh     chan *core.Transaction
+	reorgCh  <-chan core.ReorgEvent
 }
 
 func NewServer(opts *ServerOpts) (*Server, error) {
 	if opts.RPCDecodeFunc == nil {
 		opts.RPCDecodeFunc = DefaultRPCDecoderFunc
 	}
+	if opts.MaxInboundPeers == 0 {
+		opts.MaxInboundPeers = DefaultMaxInboundPeers
+	}
+	if opts.MaxOutboundPeers == 0 {
+		opts.MaxOutboundPeers = DefaultMaxOutboundPeers
+	}
 
-	genesisBlock, err := core.GenesisBlock()
+	genesisBlock, err := core.GenesisBlock(opts.GenesisAlloc)
 	if err != nil {
 		return nil, err
 	}
 
-	chain, err := core.NewBlockchain(genesisBlock)
+	chainOpts := &core.BlockchainOpts{
+		ChainID: opts.ChainID,
+	}
+	if opts.DataDir != "" {
+		store, err := core.NewFileStore(opts.DataDir)
+		if err != nil {
+			return nil, err
+		}
+		chainOpts.Store = store
+	}
+
+	chain, err := core.NewBlockchain(genesisBlock, chainOpts)
 	if err != nil {
 		return nil, err
 	}
@@ -75,10 +124,11 @@ func NewServer(opts *ServerOpts) (*Server, error) {
 		chain:        chain,
 		isValidator:  opts.PrivateKey != nil,
 		peerChan:     peerChan,
-		peerMap:      make(map[NetAddr]*TCPPeer),
+		peerMap:      make(map[string]*TCPPeer),
 		rpcCh:        make(chan RPC),
 		quitChan:     make(chan struct{}, 1),
 		txCh:         txChan,
+		reorgCh:      chain.SubscribeReorgs(),
 	}
 
 	if opts.RPCProcessor == nil {
@@ -93,25 +143,29 @@ func NewServer(opts *ServerOpts) (*Server, error) {
 }
 
 func (s *Server) Start() {
-	s.TCPTransport.Start()
+	if err := s.TCPTransport.Start(); err != nil {
+		fmt.Println("failed to start TCP transport", err)
+		return
+	}
+
+	for _, addr := range s.SeedNodes {
+		go s.dialLoop(addr)
+	}
+
 free:
 	for {
 		select {
 		case peer := <-s.peerChan:
-			s.peerMapMU.Lock()
-			defer s.peerMapMU.Unlock()
-
-			fmt.Printf("new peer %+v\n", peer)
-
-			s.peerMap[peer.conn.RemoteAddr()] = peer
-
-			go peer.readLoop(s.rpcCh)
+			s.addPeer(peer)
 
 		case tx := <-s.txCh:
 			if err := s.processTransaction(tx); err != nil {
 				fmt.Println("process TX error", err)
 			}
 
+		case ev := <-s.reorgCh:
+			s.processReorg(ev)
+
 		case rpc := <-s.rpcCh:
 			msg, err := s.RPCDecodeFunc(rpc)
 			if err != nil {
@@ -129,20 +183,161 @@ free:
 	fmt.Println("Server shutdown")
 }
 
-// func (s *Server) bootstrapNodes() {
-// 	for _, tr := range s.Transports {
-// 		if s.Transport.Addr() != tr.Addr() {
-// 			if err := s.Transport.Connect(tr); err != nil {
-// 				fmt.Println("error, could not connect to remote", err)
-// 			}
-// 			fmt.Println("msg, connect to remote", tr.Addr())
+// dialLoop keeps a connection to the node at addr, dialing it again with
+// an increasing delay whenever the connection fails or drops.
+func (s *Server) dialLoop(addr string) {
+	backoff := minDialBackoff
 
-// 			if err := s.sendGetStatusMessage(tr); err != nil {
-// 				fmt.Println("error, sendGetStatusMessage", err)
-// 			}
-// 		}
-// 	}
-// }
+	for {
+		peer, err := s.TCPTransport.Dial(addr)
+		if err == nil {
+			connected := time.Now()
+			s.peerChan <- peer
+			<-peer.closed
+
+			// A connection that stayed up for a while is not a sign of
+			// trouble, so the next attempt starts with the shortest delay.
+			if time.Since(connected) > maxDialBackoff {
+				backoff = minDialBackoff
+			}
+			err = fmt.Errorf("connection closed")
+		}
+
+		fmt.Printf("seed node %s: %s, retrying in %s\n", addr, err, backoff)
+		time.Sleep(backoff)
+
+		backoff *= 2
+		if backoff > maxDialBackoff {
+			backoff = maxDialBackoff
+		}
+	}
+}
+
+// addPeer registers a new connection and starts reading from it, unless
+// that would exceed the peer limit for its direction. Outbound peers are
+// asked for their status right away.
+func (s *Server) addPeer(peer *TCPPeer) {
+	addr := peer.conn.RemoteAddr().String()
+
+	s.peerMapMU.Lock()
+	inbound, outbound := 0, 0
+	for _, p := range s.peerMap {
+		if p.outbound {
+			outbound++
+		} else {
+			inbound++
+		}
+	}
+	if (peer.outbound && outbound >= s.MaxOutboundPeers) || (!peer.outbound && inbound >= s.MaxInboundPeers) {
+		s.peerMapMU.Unlock()
+		fmt.Printf("dropping peer %s: too many peers\n", addr)
+		peer.Close()
+		return
+	}
+	s.peerMap[addr] = peer
+	s.peerMapMU.Unlock()
+
+	fmt.Printf("new peer %s\n", addr)
+
+	go func() {
+		defer s.removePeer(peer)
+
+		if err := s.handshake(peer); err != nil {
+			fmt.Printf("handshake with %s failed: %s\n", addr, err)
+			peer.Close()
+			return
+		}
+
+		if peer.outbound {
+			if err := s.sendGetStatusMessage(peer); err != nil {
+				fmt.Printf("failed to send get status message to %s: %s\n", addr, err)
+			}
+		}
+
+		peer.readLoop(s.rpcCh)
+	}()
+}
+
+// handshake sends our handshake to a new peer and checks the one it sends
+// back: the peer must speak the same protocol version and be on the same
+// chain. On success the peer's handshake is stored on it.
+func (s *Server) handshake(peer *TCPPeer) error {
+	genesis, err := s.chain.GetHeader(0)
+	if err != nil {
+		return err
+	}
+	ours := &HandshakeMessage{
+		Version:     ProtocolVersion,
+		ChainID:     s.chain.ChainID(),
+		GenesisHash: core.BlockHasher{}.Hash(genesis),
+		NodeID:      s.ID,
+		ListenAddr:  s.ListenAddr,
+	}
+	if err := peer.Send(NewMessage(MessageTypeHandshake, ours.Bytes()).Bytes()); err != nil {
+		return err
+	}
+
+	if err := peer.conn.SetReadDeadline(time.Now().Add(handshakeTimeout)); err != nil {
+		return err
+	}
+	data, err := ReadFrame(peer.reader)
+	if err != nil {
+		return err
+	}
+	if err := peer.conn.SetReadDeadline(time.Time{}); err != nil {
+		return err
+	}
+
+	msg := Message{}
+	if err := msg.Decode(bytes.NewReader(data)); err != nil {
+		return err
+	}
+	if msg.Header != MessageTypeHandshake {
+		return fmt.Errorf("expected a handshake, got message type %x", msg.Header)
+	}
+	theirs := new(HandshakeMessage)
+	if err := theirs.Decode(msg.Data); err != nil {
+		return err
+	}
+
+	switch {
+	case theirs.Version != ours.Version:
+		return fmt.Errorf("peer speaks protocol version %d, expected %d", theirs.Version, ours.Version)
+	case theirs.ChainID != ours.ChainID:
+		return fmt.Errorf("peer is on chain %d, expected %d", theirs.ChainID, ours.ChainID)
+	case theirs.GenesisHash != ours.GenesisHash:
+		return fmt.Errorf("peer has genesis %s, expected %s", theirs.GenesisHash, ours.GenesisHash)
+	case theirs.NodeID != "" && theirs.NodeID == ours.NodeID:
+		return fmt.Errorf("connected to self")
+	}
+
+	peer.handshake = theirs
+
+	return nil
+}
+
+func (s *Server) removePeer(peer *TCPPeer) {
+	addr := peer.conn.RemoteAddr().String()
+
+	s.peerMapMU.Lock()
+	defer s.peerMapMU.Unlock()
+
+	if s.peerMap[addr] == peer {
+		delete(s.peerMap, addr)
+		fmt.Printf("peer %s disconnected\n", addr)
+	}
+}
+
+func (s *Server) getPeer(addr NetAddr) (*TCPPeer, error) {
+	s.peerMapMU.RLock()
+	defer s.peerMapMU.RUnlock()
+
+	peer, ok := s.peerMap[addr.String()]
+	if !ok {
+		return nil, fmt.Errorf("peer %s not known", addr)
+	}
+	return peer, nil
+}
 
 func (s *Server) validatorLoop() {
 	ticker := time.NewTicker(s.BlockTime)
@@ -172,16 +367,9 @@ func (s *Server) ProcessMessage(msg *DecodedMessage) error {
 }
 
 func (s *Server) sendGetStatusMessage(peer *TCPPeer) error {
-	var (
-		getStatusMessage = new(GetStatusMessage)
-		buf              = new(bytes.Buffer)
-	)
-
-	if err := gob.NewEncoder(buf).Encode(getStatusMessage); err != nil {
-		return err
-	}
+	getStatusMessage := new(GetStatusMessage)
 
-	msg := NewMessage(MessageTypeGetStatus, buf.Bytes())
+	msg := NewMessage(MessageTypeGetStatus, getStatusMessage.Bytes())
 	return peer.Send(msg.Bytes())
 }
 
@@ -201,21 +389,14 @@ func (s *Server) processGetStatusMessage(from NetAddr, data *GetStatusMessage) e
 	statusMessage := &StatusMessage{
 		CurrentHeight: s.chain.Height(),
 		ID:            s.ID,
+		Version:       ProtocolVersion,
 	}
 
-	buf := new(bytes.Buffer)
-	if err := gob.NewEncoder(buf).Encode(statusMessage); err != nil {
+	peer, err := s.getPeer(from)
+	if err != nil {
 		return err
 	}
-
-	s.peerMapMU.RLock()
-	defer s.peerMapMU.RUnlock()
-
-	peer, ok := s.peerMap[from]
-	if !ok {
-		return fmt.Errorf("peer %s not known", from)
-	}
-	msg := NewMessage(MessageTypeStatus, buf.Bytes())
+	msg := NewMessage(MessageTypeStatus, statusMessage.Bytes())
 	return peer.Send(msg.Bytes())
 }
 
@@ -225,39 +406,65 @@ func (s *Server) processStatusMessage(from NetAddr, data *StatusMessage) error {
 		return nil
 	}
 
-	return s.requestBlockLoop(from)
+	return s.requestBlocks(from)
 }
 
 func (s *Server) processGetBlockMessage(from NetAddr, data *GetBlockMessage) error {
 	fmt.Println("msg | received getBlocks message | from", from)
 
 	blocks := []*core.Block{}
-
 	if data.To == 0 {
-		for i := int(data.From); i < int(s.chain.Height()); i++ {
-			block, err := s.chain.GetBlockByHeight(uint32(i))
-			if err != nil {
-				return err
-			}
-			blocks = append(blocks, block)
+		var err error
+		if blocks, err = s.blocksFrom(data.From, MaxFrameSize); err != nil {
+			return err
 		}
 	}
 	blocksMessage := &BlocksMessage{
 		Blocks: blocks,
 	}
-	buf := new(bytes.Buffer)
-	if err := gob.NewEncoder(buf).Encode(blocksMessage); err != nil {
+	payload, err := blocksMessage.Bytes()
+	if err != nil {
 		return err
 	}
 
-	peer, ok := s.peerMap[from]
-	if !ok {
-		return fmt.Errorf("peer %s not known", from)
+	peer, err := s.getPeer(from)
+	if err != nil {
+		return err
 	}
-	msg := NewMessage(MessageTypeGetBlocks, buf.Bytes())
+	msg := NewMessage(MessageTypeBlocks, payload)
 	return peer.Send(msg.Bytes())
 }
 
+// processBlocksMessage imports the blocks a peer sent in reply to a
+// GetBlockMessage. If it sent any, the peer is asked for its status again,
+// which requests the next batch until we have caught up with it.
+// blocksFrom returns the canonical blocks from the given height up, as many
+// as fit in a BlocksMessage of at most maxSize bytes. The peer asks for the
+// rest once it has imported them.
+func (s *Server) blocksFrom(from uint32, maxSize int) ([]*core.Block, error) {
+	blocks := []*core.Block{}
+	size := blocksMessageOverhead
+
+	height := s.chain.Height()
+	for i := from; i <= height && len(blocks) < maxBlocksPerMessage; i++ {
+		block, err := s.chain.GetBlockByHeight(i)
+		if err != nil {
+			return nil, err
+		}
+		n, err := encodedBlockSize(block)
+		if err != nil {
+			return nil, err
+		}
+		if size+n > maxSize {
+			break
+		}
+		size += n
+		blocks = append(blocks, block)
+	}
+
+	return blocks, nil
+}
+
 func (s *Server) processBlocksMessage(from NetAddr, data *BlocksMessage) error {
 	fmt.Println("msg | received blocks message | from", from)
 
@@ -266,8 +473,15 @@ func (s *Server) processBlocksMessage(from NetAddr, data *BlocksMessage) error {
 			return err
 		}
 	}
+	if len(data.Blocks) == 0 {
+		return nil
+	}
 
-	return nil
+	peer, err := s.getPeer(from)
+	if err != nil {
+		return err
+	}
+	return s.sendGetStatusMessage(peer)
 }
 
 func (s *Server) processBlock(block *core.Block) error {
@@ -280,6 +494,21 @@ func (s *Server) processBlock(block *core.Block) error {
 	return nil
 }
 
+// processReorg puts the transactions of blocks dropped from the canonical
+// chain back into the mempool unless the new chain already includes them.
+func (s *Server) processReorg(ev core.ReorgEvent) {
+	fmt.Printf("chain reorganized from %s to %s (dropped %d, added %d blocks)\n", ev.OldHead, ev.NewHead, len(ev.Removed), len(ev.Added))
+
+	for _, b := range ev.Removed {
+		for _, tx := range b.Transactions {
+			if _, err := s.chain.GetTxByHash(tx.Hash(core.TxHasher{})); err == nil {
+				continue
+			}
+			s.memPool.Restore(tx)
+		}
+	}
+}
+
 func (s *Server) processTransaction(tx *core.Transaction) error {
 	hash := tx.Hash(core.TxHasher{})
 
@@ -292,6 +521,14 @@ func (s *Server) processTransaction(tx *core.Transaction) error {
 		return err
 	}
 
+	if err := s.validateNonce(tx); err != nil {
+		return err
+	}
+
+	if err := s.validateGas(tx); err != nil {
+		return err
+	}
+
 	tx.SetFirstSeen(time.Now().UnixNano())
 
 	fmt.Printf("adding new transaction to mempool. hash: %s", hash)
@@ -303,37 +540,69 @@ func (s *Server) processTransaction(tx *core.Transaction) error {
 	return nil
 }
 
-func (s *Server) requestBlockLoop(peerAddr NetAddr) error {
-	ticker := time.NewTicker(3 * time.Second)
+// validateNonce rejects transactions that are for another chain or whose
+// nonce does not directly follow the sender's last known transaction,
+// counting those still waiting in the mempool.
+func (s *Server) validateNonce(tx *core.Transaction) error {
+	if tx.ChainID != s.chain.ChainID() {
+		return fmt.Errorf("transaction has chain id %d, expected %d", tx.ChainID, s.chain.ChainID())
+	}
 
-	for {
-		getBlocksMessage := &GetBlockMessage{
-			From: s.chain.Height() + 1,
-			To:   0,
-		}
+	// Pending transactions are only cleared once their block is added, so
+	// reading them before the account never misses a nonce in between.
+	from := tx.From.Address()
+	next, pending := s.memPool.PendingNonce(from)
+	acc, err := s.chain.GetAccount(from)
+	if err != nil {
+		return err
+	}
 
-		buf := new(bytes.Buffer)
-		if err := gob.NewEncoder(buf).Encode(getBlocksMessage); err != nil {
-			return err
-		}
+	if tx.Nonce < acc.Nonce {
+		return fmt.Errorf("transaction nonce %d is stale, account %s is at nonce %d", tx.Nonce, from, acc.Nonce)
+	}
 
-		s.peerMapMU.RLock()
-		defer s.peerMapMU.RUnlock()
+	expected := acc.Nonce
+	if pending && next > expected {
+		expected = next
+	}
+	if tx.Nonce != expected {
+		return fmt.Errorf("transaction nonce %d does not match expected nonce %d for account %s", tx.Nonce, expected, from)
+	}
 
-		peer, ok := s.peerMap[peerAddr]
-		if !ok {
-			return fmt.Errorf("peer %s not known", peerAddr)
-		}
-		if err := peer.Send(buf.Bytes()); err != nil {
-			fmt.Println("error | failed to send to peer | err |", err)
-		}
-		<-ticker.C
+	return nil
+}
+
+// validateGas rejects transactions that could never be included in a block.
+func (s *Server) validateGas(tx *core.Transaction) error {
+	if intrinsicGas := tx.IntrinsicGas(); tx.GasLimit < intrinsicGas {
+		return fmt.Errorf("transaction gas limit %d is below its intrinsic gas %d", tx.GasLimit, intrinsicGas)
+	}
+	if tx.GasLimit > s.chain.BlockGasLimit() {
+		return fmt.Errorf("transaction gas limit %d exceeds the block gas limit %d", tx.GasLimit, s.chain.BlockGasLimit())
+	}
+	return nil
+}
+
+// requestBlocks asks the peer for the blocks above our height. It sends a
+// single request and returns; the reply is handled by processBlocksMessage.
+func (s *Server) requestBlocks(peerAddr NetAddr) error {
+	getBlocksMessage := &GetBlockMessage{
+		From: s.chain.Height() + 1,
+		To:   0,
+	}
+
+	msg := NewMessage(MessageTypeGetBlocks, getBlocksMessage.Bytes())
+
+	peer, err := s.getPeer(peerAddr)
+	if err != nil {
+		return err
 	}
+	return peer.Send(msg.Bytes())
 }
 
 func (s *Server) broadcastBlock(b *core.Block) error {
 	buf := &bytes.Buffer{}
-	if err := b.Encode(core.NewGobBlockEncoder(buf)); err != nil {
+	if err := b.Encode(core.NewBinaryBlockEncoder(buf)); err != nil {
 		return err
 	}
 	msg := NewMessage(MessageTypeBlock, buf.Bytes())
@@ -343,7 +612,7 @@ func (s *Server) broadcastBlock(b *core.Block) error {
 
 // func (s *Server) broadcastTx(tx *core.Transaction) error {
 // 	buf := &bytes.Buffer{}
-// 	if err := tx.Encode(core.NewGobTxEncoder(buf)); err != nil {
+// 	if err := tx.Encode(core.NewBinaryTxEncoder(buf)); err != nil {
 // 		return err
 // 	}
 // 	msg := NewMessage(MessageTypeTx, buf.Bytes())
@@ -364,15 +633,28 @@ func (s *Server) createNewBlock() error {
 		return err
 	}
 
+	invalid, err := s.chain.PrepareBlock(block)
+	if err != nil {
+		return err
+	}
+
 	if err = block.Sign(*s.PrivateKey); err != nil {
 		return err
 	}
 
-	s.memPool.ClearPending()
+	if err = s.chain.AddBlock(block); err != nil {
+		return err
+	}
+
+	// Only the included transactions are removed: others may have been
+	// added to the pool while the block was being made, and those that did
+	// not fit are left for the next block. Invalid ones are dropped.
+	s.memPool.RemovePending(block.Transactions)
+	s.memPool.Remove(invalid)
 
 	go s.broadcastBlock(block)
 
-	return s.chain.AddBlock(block)
+	return nil
 }
 
 // func (s *Server) initTransports() {
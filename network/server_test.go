@@ -0,0 +1,407 @@
+package network
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/3ssalunke/go-blockchain/core"
+	"github.com/3ssalunke/go-blockchain/crypto"
+	"github.com/stretchr/testify/assert"
+)
+
+// freeAddr returns a local address nothing is listening on.
+func freeAddr(t *testing.T) string {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	defer ln.Close()
+	return ln.Addr().String()
+}
+
+func newTestServer(t *testing.T, opts *ServerOpts) *Server {
+	if opts.ListenAddr == "" {
+		opts.ListenAddr = freeAddr(t)
+	}
+	s, err := NewServer(opts)
+	assert.Nil(t, err)
+	go s.Start()
+	t.Cleanup(func() { s.quitChan <- struct{}{} })
+	return s
+}
+
+func peerCount(s *Server) int {
+	s.peerMapMU.RLock()
+	defer s.peerMapMU.RUnlock()
+	return len(s.peerMap)
+}
+
+type recordingProcessor struct {
+	msgs chan *DecodedMessage
+}
+
+func (p *recordingProcessor) ProcessMessage(msg *DecodedMessage) error {
+	p.msgs <- msg
+	return nil
+}
+
+func TestServerSeedNodes(t *testing.T) {
+	a := newTestServer(t, &ServerOpts{ID: "a"})
+
+	recorder := &recordingProcessor{msgs: make(chan *DecodedMessage, 1)}
+	b := newTestServer(t, &ServerOpts{
+		ID:           "b",
+		SeedNodes:    []string{a.ListenAddr},
+		RPCProcessor: recorder,
+	})
+
+	// b dials a and asks for its status, which a answers.
+	select {
+	case msg := <-recorder.msgs:
+		status, ok := msg.Data.(*StatusMessage)
+		assert.True(t, ok)
+		assert.Equal(t, "a", status.ID)
+	case <-time.After(5 * time.Second):
+		t.Fatal("no status message received from seed node")
+	}
+
+	assert.Equal(t, 1, peerCount(a))
+	assert.Equal(t, 1, peerCount(b))
+
+	b.peerMapMU.RLock()
+	defer b.peerMapMU.RUnlock()
+	for _, peer := range b.peerMap {
+		assert.Equal(t, "a", peer.Handshake().NodeID)
+		assert.Equal(t, a.ListenAddr, peer.Handshake().ListenAddr)
+	}
+}
+
+func TestServerReconnectsToSeedNodes(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	defer ln.Close()
+
+	newTestServer(t, &ServerOpts{SeedNodes: []string{ln.Addr().String()}})
+
+	// Every connection starts with a handshake; dropping it makes the node
+	// dial again.
+	for i := 0; i < 2; i++ {
+		conn, err := ln.Accept()
+		assert.Nil(t, err)
+		assert.Nil(t, conn.SetReadDeadline(time.Now().Add(5*time.Second)))
+
+		data, err := ReadFrame(conn)
+		assert.Nil(t, err)
+		msg := Message{}
+		assert.Nil(t, msg.Decode(bytes.NewReader(data)))
+		assert.Equal(t, MessageTypeHandshake, msg.Header)
+
+		conn.Close()
+	}
+}
+
+func TestServerMaxInboundPeers(t *testing.T) {
+	s := newTestServer(t, &ServerOpts{MaxInboundPeers: 1})
+
+	var first net.Conn
+	assert.Eventually(t, func() bool {
+		conn, err := net.Dial("tcp", s.ListenAddr)
+		if err != nil {
+			return false
+		}
+		first = conn
+		return true
+	}, 5*time.Second, 10*time.Millisecond)
+	defer first.Close()
+	assert.Eventually(t, func() bool { return peerCount(s) == 1 }, 5*time.Second, 10*time.Millisecond)
+
+	// The second connection is closed right away.
+	second, err := net.Dial("tcp", s.ListenAddr)
+	assert.Nil(t, err)
+	defer second.Close()
+	assert.Nil(t, second.SetReadDeadline(time.Now().Add(5*time.Second)))
+	_, err = second.Read(make([]byte, 1))
+	assert.Equal(t, io.EOF, err)
+	assert.Equal(t, 1, peerCount(s))
+}
+
+// readHandshake reads the handshake a server sends on a new connection.
+func readHandshake(t *testing.T, conn net.Conn) *HandshakeMessage {
+	data, err := ReadFrame(conn)
+	assert.Nil(t, err)
+	msg := Message{}
+	assert.Nil(t, msg.Decode(bytes.NewReader(data)))
+	assert.Equal(t, MessageTypeHandshake, msg.Header)
+
+	hs := new(HandshakeMessage)
+	assert.Nil(t, hs.Decode(msg.Data))
+	return hs
+}
+
+func dialTestServer(t *testing.T, s *Server) net.Conn {
+	var conn net.Conn
+	assert.Eventually(t, func() bool {
+		c, err := net.Dial("tcp", s.ListenAddr)
+		if err != nil {
+			return false
+		}
+		conn = c
+		return true
+	}, 5*time.Second, 10*time.Millisecond)
+	t.Cleanup(func() { conn.Close() })
+	assert.Nil(t, conn.SetReadDeadline(time.Now().Add(5*time.Second)))
+	return conn
+}
+
+func TestServerHandshake(t *testing.T) {
+	s := newTestServer(t, &ServerOpts{ID: "node"})
+	conn := dialTestServer(t, s)
+
+	hs := readHandshake(t, conn)
+	assert.Equal(t, ProtocolVersion, hs.Version)
+	assert.Equal(t, s.chain.ChainID(), hs.ChainID)
+	assert.Equal(t, "node", hs.NodeID)
+	assert.Equal(t, s.ListenAddr, hs.ListenAddr)
+
+	hs.NodeID = "other"
+	assert.Nil(t, WriteFrame(conn, NewMessage(MessageTypeHandshake, hs.Bytes()).Bytes()))
+
+	// Once the handshake is done the server answers other messages.
+	assert.Nil(t, WriteFrame(conn, NewMessage(MessageTypeGetStatus, (&GetStatusMessage{}).Bytes()).Bytes()))
+	data, err := ReadFrame(conn)
+	assert.Nil(t, err)
+	decoded, err := DefaultRPCDecoderFunc(RPC{Payload: bytes.NewReader(data)})
+	assert.Nil(t, err)
+	status := decoded.Data.(*StatusMessage)
+	assert.Equal(t, "node", status.ID)
+	assert.Equal(t, ProtocolVersion, status.Version)
+}
+
+func TestServerHandshakeMismatch(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(hs *HandshakeMessage)
+	}{
+		{"version", func(hs *HandshakeMessage) { hs.Version++ }},
+		{"chain id", func(hs *HandshakeMessage) { hs.ChainID++ }},
+		{"genesis", func(hs *HandshakeMessage) { hs.GenesisHash[0] ^= 0xff }},
+		{"self", func(hs *HandshakeMessage) {}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestServer(t, &ServerOpts{ID: "node"})
+			conn := dialTestServer(t, s)
+
+			hs := readHandshake(t, conn)
+			tt.modify(hs)
+			assert.Nil(t, WriteFrame(conn, NewMessage(MessageTypeHandshake, hs.Bytes()).Bytes()))
+
+			_, err := conn.Read(make([]byte, 1))
+			assert.Equal(t, io.EOF, err)
+			assert.Eventually(t, func() bool { return peerCount(s) == 0 }, 5*time.Second, 10*time.Millisecond)
+		})
+	}
+}
+
+func TestServerHandshakeRequired(t *testing.T) {
+	s := newTestServer(t, &ServerOpts{ID: "node"})
+	conn := dialTestServer(t, s)
+	readHandshake(t, conn)
+
+	// Any other message in place of the handshake drops the connection.
+	assert.Nil(t, WriteFrame(conn, NewMessage(MessageTypeGetStatus, (&GetStatusMessage{}).Bytes()).Bytes()))
+	_, err := conn.Read(make([]byte, 1))
+	assert.Equal(t, io.EOF, err)
+}
+
+func TestCreateNewBlockNonces(t *testing.T) {
+	validatorKey := crypto.GeneratePrivateKey()
+	// The validator loop is kept out of the way; blocks are made by hand.
+	s, err := NewServer(&ServerOpts{ID: "validator", PrivateKey: &validatorKey, BlockTime: time.Hour})
+	assert.Nil(t, err)
+
+	senderKey := crypto.GeneratePrivateKey()
+	newTx := func(nonce uint64) *core.Transaction {
+		tx := core.NewTransaction(nil)
+		tx.Nonce = nonce
+		assert.Nil(t, tx.Sign(senderKey))
+		return tx
+	}
+
+	for nonce := uint64(0); nonce < 50; nonce++ {
+		tx := newTx(nonce)
+		assert.Nil(t, s.processTransaction(tx))
+
+		// While the block is being made, the transaction it includes must
+		// never look like the next one to send again, nor may the next
+		// nonce be refused.
+		done := make(chan error)
+		go func() { done <- s.createNewBlock() }()
+		for running := true; running; {
+			select {
+			case err := <-done:
+				assert.Nil(t, err)
+				running = false
+			default:
+				assert.NotNil(t, s.validateNonce(newTx(nonce)))
+				assert.Nil(t, s.validateNonce(newTx(nonce+1)))
+			}
+		}
+
+		assert.Equal(t, uint32(nonce+1), s.chain.Height())
+		assert.Equal(t, 0, s.memPool.PendingCount())
+	}
+}
+
+func TestServerSyncFromSeedNode(t *testing.T) {
+	validatorKey := crypto.GeneratePrivateKey()
+	seed := newTestServer(t, &ServerOpts{ID: "seed", PrivateKey: &validatorKey, BlockTime: time.Hour})
+	for i := 0; i < 5; i++ {
+		assert.Nil(t, seed.createNewBlock())
+	}
+
+	// The node imports the seed's blocks, which needs the replies to be
+	// handled while it syncs.
+	s := newTestServer(t, &ServerOpts{ID: "node", SeedNodes: []string{seed.ListenAddr}})
+
+	assert.Eventually(t, func() bool { return s.chain.Height() == seed.chain.Height() }, 5*time.Second, 10*time.Millisecond)
+	for h := uint32(0); h <= seed.chain.Height(); h++ {
+		want, err := seed.chain.GetHeader(h)
+		assert.Nil(t, err)
+		got, err := s.chain.GetHeader(h)
+		assert.Nil(t, err)
+		assert.Equal(t, core.BlockHasher{}.Hash(want), core.BlockHasher{}.Hash(got))
+	}
+}
+
+func TestCreateNewBlockLeftOutTransactions(t *testing.T) {
+	validatorKey := crypto.GeneratePrivateKey()
+	s, err := NewServer(&ServerOpts{ID: "validator", PrivateKey: &validatorKey, BlockTime: time.Hour})
+	assert.Nil(t, err)
+
+	// Only one of the two fits in a block.
+	big := make([]*core.Transaction, 2)
+	for i := range big {
+		big[i] = core.NewTransaction(nil)
+		big[i].GasLimit = s.chain.BlockGasLimit()
+		assert.Nil(t, big[i].Sign(crypto.GeneratePrivateKey()))
+		assert.Nil(t, s.processTransaction(big[i]))
+	}
+	// An unfunded transfer is invalid.
+	transfer := core.NewTransaction(nil)
+	transfer.Type = core.TxTypeTransfer
+	transfer.Value = 1
+	assert.Nil(t, transfer.Sign(crypto.GeneratePrivateKey()))
+	assert.Nil(t, s.processTransaction(transfer))
+
+	assert.Nil(t, s.createNewBlock())
+	b, err := s.chain.GetBlockByHeight(1)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(b.Transactions))
+	assert.Equal(t, 1, s.memPool.PendingCount())
+
+	// The invalid transfer is forgotten, so it can be sent again.
+	assert.False(t, s.memPool.Contains(transfer.Hash(core.TxHasher{})))
+
+	assert.Nil(t, s.createNewBlock())
+	for _, tx := range big {
+		_, err := s.chain.GetTxByHash(tx.Hash(core.TxHasher{}))
+		assert.Nil(t, err)
+	}
+	assert.Equal(t, 0, s.memPool.PendingCount())
+}
+
+func TestCreateNewBlockKeepsLateTransactions(t *testing.T) {
+	validatorKey := crypto.GeneratePrivateKey()
+	s, err := NewServer(&ServerOpts{ID: "validator", PrivateKey: &validatorKey, BlockTime: time.Hour})
+	assert.Nil(t, err)
+
+	// Transactions keep arriving while blocks are made; each must end up
+	// either in a block or still pending.
+	txx := make([]*core.Transaction, 200)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := range txx {
+			txx[i] = core.NewTransaction(nil)
+			assert.Nil(t, txx[i].Sign(crypto.GeneratePrivateKey()))
+			assert.Nil(t, s.processTransaction(txx[i]))
+		}
+	}()
+	for running := true; running; {
+		select {
+		case <-done:
+			running = false
+		default:
+			assert.Nil(t, s.createNewBlock())
+		}
+	}
+
+	for _, tx := range txx {
+		hash := tx.Hash(core.TxHasher{})
+		_, err := s.chain.GetTxByHash(hash)
+		pending := false
+		for _, p := range s.memPool.Pending() {
+			pending = pending || p.Hash(core.TxHasher{}) == hash
+		}
+		assert.True(t, err == nil || pending, "transaction %s was lost", hash)
+	}
+}
+
+func TestServerReorgRestoresTransactions(t *testing.T) {
+	validatorKey := crypto.GeneratePrivateKey()
+	s := newTestServer(t, &ServerOpts{ID: "validator", PrivateKey: &validatorKey, BlockTime: time.Hour})
+
+	tx := core.NewTransaction(nil)
+	assert.Nil(t, tx.Sign(crypto.GeneratePrivateKey()))
+	assert.Nil(t, s.processTransaction(tx))
+	assert.Nil(t, s.createNewBlock())
+	_, err := s.chain.GetTxByHash(tx.Hash(core.TxHasher{}))
+	assert.Nil(t, err)
+
+	// Another validator on the same genesis makes a longer branch without
+	// the transaction.
+	otherKey := crypto.GeneratePrivateKey()
+	other, err := NewServer(&ServerOpts{ID: "other", PrivateKey: &otherKey, BlockTime: time.Hour})
+	assert.Nil(t, err)
+	for i := 0; i < 2; i++ {
+		assert.Nil(t, other.createNewBlock())
+	}
+	for h := uint32(1); h <= 2; h++ {
+		b, err := other.chain.GetBlockByHeight(h)
+		assert.Nil(t, err)
+		assert.Nil(t, s.chain.AddBlock(b))
+	}
+
+	_, err = s.chain.GetTxByHash(tx.Hash(core.TxHasher{}))
+	assert.NotNil(t, err)
+	assert.Eventually(t, func() bool { return s.memPool.PendingCount() == 1 }, 5*time.Second, 10*time.Millisecond)
+	assert.Equal(t, tx.Hash(core.TxHasher{}), s.memPool.Pending()[0].Hash(core.TxHasher{}))
+}
+
+func TestBlocksFromMaxSize(t *testing.T) {
+	validatorKey := crypto.GeneratePrivateKey()
+	s, err := NewServer(&ServerOpts{ID: "validator", PrivateKey: &validatorKey, BlockTime: time.Hour})
+	assert.Nil(t, err)
+	for i := 0; i < 5; i++ {
+		assert.Nil(t, s.createNewBlock())
+	}
+
+	all, err := s.blocksFrom(1, MaxFrameSize)
+	assert.Nil(t, err)
+	assert.Equal(t, 5, len(all))
+
+	// Room for two blocks: the reply stops short and still fits.
+	first, err := encodedBlockSize(all[0])
+	assert.Nil(t, err)
+	maxSize := blocksMessageOverhead + 2*first + first/2
+	blocks, err := s.blocksFrom(1, maxSize)
+	assert.Nil(t, err)
+	assert.Equal(t, all[:2], blocks)
+
+	payload, err := (&BlocksMessage{Blocks: blocks}).Bytes()
+	assert.Nil(t, err)
+	assert.LessOrEqual(t, len(NewMessage(MessageTypeBlocks, payload).Bytes()), maxSize)
+}
@@ -1,31 +1,105 @@
 package network
 
 import (
+	"bufio"
 	"bytes"
+	"encoding/binary"
+	"errors"
 	"fmt"
+	"io"
 	"net"
+	"sync"
+	"time"
 )
 
+// MaxFrameSize is the largest message a peer may send. Frames announcing a
+// larger message are rejected before anything is allocated for them.
+const MaxFrameSize = 16 << 20
+
+// frameHeaderSize is the size of the length prefixed to every message.
+const frameHeaderSize = 4
+
+// dialTimeout bounds how long connecting to a peer may take.
+const dialTimeout = 5 * time.Second
+
+// writeTimeout bounds how long sending a message to a peer may take, so a
+// peer that stops reading cannot hold up the sender.
+const writeTimeout = 10 * time.Second
+
 type TCPPeer struct {
-	conn net.Conn
+	conn   net.Conn
+	reader *bufio.Reader
+	// writeLock keeps concurrent sends from interleaving their frames.
+	writeLock    sync.Mutex
+	writeTimeout time.Duration
+	// outbound is set if this node dialed the peer rather than accepted
+	// its connection.
+	outbound bool
+	// handshake is what the peer announced when it connected. It is set
+	// before any other message from the peer is processed.
+	handshake *HandshakeMessage
+
+	closeOnce sync.Once
+	closed    chan struct{}
+}
+
+func NewTCPPeer(conn net.Conn) *TCPPeer {
+	return &TCPPeer{
+		conn:         conn,
+		reader:       bufio.NewReader(conn),
+		writeTimeout: writeTimeout,
+		closed:       make(chan struct{}),
+	}
+}
+
+// Close closes the connection to the peer. It is safe to call more than
+// once.
+func (p *TCPPeer) Close() error {
+	var err error
+	p.closeOnce.Do(func() {
+		err = p.conn.Close()
+		close(p.closed)
+	})
+	return err
 }
 
+// Handshake returns the handshake the peer sent, or nil if it has not
+// completed one.
+func (p *TCPPeer) Handshake() *HandshakeMessage {
+	return p.handshake
+}
+
+// Send writes data to the peer as a single frame. If the write fails or does
+// not complete within the write timeout the connection is closed, since a
+// partly written frame leaves it unusable.
 func (p *TCPPeer) Send(data []byte) error {
-	if _, err := p.conn.Write(data); err != nil {
+	p.writeLock.Lock()
+	defer p.writeLock.Unlock()
+
+	if err := p.conn.SetWriteDeadline(time.Now().Add(p.writeTimeout)); err != nil {
+		return err
+	}
+	if err := WriteFrame(p.conn, data); err != nil {
+		p.Close()
 		return err
 	}
+
 	return nil
 }
 
+// readLoop reads frames until the connection fails, passing each one on as
+// an RPC. The connection is closed when it returns.
 func (p *TCPPeer) readLoop(rpcCh chan RPC) {
-	buf := make([]byte, 2048)
+	defer p.Close()
+
 	for {
-		n, err := p.conn.Read(buf)
+		msg, err := ReadFrame(p.reader)
 		if err != nil {
-			fmt.Printf("read error from %+v\n", err)
-			continue
+			if err != io.EOF {
+				fmt.Printf("read error from %s: %s\n", p.conn.RemoteAddr(), err)
+			}
+			return
 		}
-		msg := buf[:n]
 		rpcCh <- RPC{
 			From:    p.conn.RemoteAddr(),
 			Payload: bytes.NewReader(msg),
@@ -33,6 +107,45 @@ func (p *TCPPeer) readLoop(rpcCh chan RPC) {
 	}
 }
 
+// WriteFrame writes data prefixed with its length as a little endian
+// uint32, in a single write.
+func WriteFrame(w io.Writer, data []byte) error {
+	if len(data) > MaxFrameSize {
+		return fmt.Errorf("message of %d bytes exceeds the maximum frame size %d", len(data), MaxFrameSize)
+	}
+
+	frame := make([]byte, frameHeaderSize, frameHeaderSize+len(data))
+	binary.LittleEndian.PutUint32(frame, uint32(len(data)))
+	frame = append(frame, data...)
+
+	_, err := w.Write(frame)
+	return err
+}
+
+// ReadFrame reads a frame written by WriteFrame and returns its data. It
+// returns io.EOF only if r ends before a new frame starts.
+func ReadFrame(r io.Reader) ([]byte, error) {
+	header := make([]byte, frameHeaderSize)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, err
+	}
+
+	n := binary.LittleEndian.Uint32(header)
+	if n > MaxFrameSize {
+		return nil, fmt.Errorf("frame of %d bytes exceeds the maximum frame size %d", n, MaxFrameSize)
+	}
+
+	data := make([]byte, n)
+	if _, err := io.ReadFull(r, data); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+
+	return data, nil
+}
+
 type TCPTransport struct {
 	listenAddr string
 	listner    net.Listener
@@ -46,19 +159,32 @@ func NewTCPTransport(addr string, peerChan chan *TCPPeer) *TCPTransport {
 	}
 }
 
+// Dial connects to the node listening at addr.
+func (t *TCPTransport) Dial(addr string) (*TCPPeer, error) {
+	conn, err := net.DialTimeout("tcp", addr, dialTimeout)
+	if err != nil {
+		return nil, err
+	}
+
+	peer := NewTCPPeer(conn)
+	peer.outbound = true
+
+	return peer, nil
+}
+
 func (t *TCPTransport) acceptLoop() {
 	for {
 		conn, err := t.listner.Accept()
+		if errors.Is(err, net.ErrClosed) {
+			return
+		}
 		if err != nil {
 			fmt.Printf("accept error from %+v\n", err)
 			continue
 		}
-		peer := &TCPPeer{
-			conn,
-		}
+		peer := NewTCPPeer(conn)
 		t.peerChan <- peer
 		fmt.Printf("new TCP incoming connection => %+v\n", conn)
-		// go t.readLoop(peer)
 	}
 }
 
@@ -0,0 +1,121 @@
+package network
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/3ssalunke/go-blockchain/core"
+	"github.com/3ssalunke/go-blockchain/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFrame(t *testing.T) {
+	buf := &bytes.Buffer{}
+	assert.Nil(t, WriteFrame(buf, []byte("hello")))
+	assert.Nil(t, WriteFrame(buf, nil))
+	assert.Nil(t, WriteFrame(buf, []byte("world")))
+
+	// Frames written back to back are read one at a time.
+	for _, want := range []string{"hello", "", "world"} {
+		data, err := ReadFrame(buf)
+		assert.Nil(t, err)
+		assert.Equal(t, want, string(data))
+	}
+	_, err := ReadFrame(buf)
+	assert.Equal(t, io.EOF, err)
+
+	assert.NotNil(t, WriteFrame(buf, make([]byte, MaxFrameSize+1)))
+
+	header := binary.LittleEndian.AppendUint32(nil, MaxFrameSize+1)
+	_, err = ReadFrame(bytes.NewReader(header))
+	assert.NotNil(t, err)
+
+	truncated := append(binary.LittleEndian.AppendUint32(nil, 10), "short"...)
+	_, err = ReadFrame(bytes.NewReader(truncated))
+	assert.Equal(t, io.ErrUnexpectedEOF, err)
+}
+
+func TestTCPTransportLargeMessages(t *testing.T) {
+	peerChan := make(chan *TCPPeer, 1)
+	tr := NewTCPTransport("127.0.0.1:0", peerChan)
+	assert.Nil(t, tr.Start())
+	defer tr.listner.Close()
+
+	conn, err := net.Dial("tcp", tr.listner.Addr().String())
+	assert.Nil(t, err)
+	defer conn.Close()
+	sender := NewTCPPeer(conn)
+
+	rpcCh := make(chan RPC)
+	go (<-peerChan).readLoop(rpcCh)
+
+	// A genesis block with many allocations is several kilobytes.
+	alloc := core.GenesisAlloc{}
+	for i := 0; i < 100; i++ {
+		alloc[types.AddressFromBytes(types.RandomBytes(20))] = 1
+	}
+	genesis, err := core.GenesisBlock(alloc)
+	assert.Nil(t, err)
+	blocks := &BlocksMessage{Blocks: []*core.Block{genesis, genesis, genesis}}
+	data, err := blocks.Bytes()
+	assert.Nil(t, err)
+	assert.Greater(t, len(data), 2048*3)
+
+	// Both messages are written before either is read, so the stream
+	// holds them back to back.
+	status := &StatusMessage{ID: "node", CurrentHeight: 1}
+	assert.Nil(t, sender.Send(NewMessage(MessageTypeBlocks, data).Bytes()))
+	assert.Nil(t, sender.Send(NewMessage(MessageTypeStatus, status.Bytes()).Bytes()))
+
+	decoded, err := DefaultRPCDecoderFunc(<-rpcCh)
+	assert.Nil(t, err)
+	msg := decoded.Data.(*BlocksMessage)
+	assert.Equal(t, 3, len(msg.Blocks))
+	for _, b := range msg.Blocks {
+		assert.Equal(t, genesis.Hash(core.BlockHasher{}), b.Hash(core.BlockHasher{}))
+	}
+
+	decoded, err = DefaultRPCDecoderFunc(<-rpcCh)
+	assert.Nil(t, err)
+	assert.Equal(t, status, decoded.Data)
+}
+
+func TestTCPPeerConcurrentSends(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	sender := NewTCPPeer(client)
+
+	rpcCh := make(chan RPC)
+	go NewTCPPeer(server).readLoop(rpcCh)
+
+	payload := bytes.Repeat([]byte{0xab}, 10_000)
+	for i := 0; i < 4; i++ {
+		go sender.Send(payload)
+	}
+	for i := 0; i < 4; i++ {
+		data, err := io.ReadAll((<-rpcCh).Payload)
+		assert.Nil(t, err)
+		assert.Equal(t, payload, data)
+	}
+}
+
+func TestTCPPeerSendTimeout(t *testing.T) {
+	client, server := net.Pipe()
+	defer server.Close()
+	peer := NewTCPPeer(client)
+	peer.writeTimeout = 50 * time.Millisecond
+
+	// Nothing reads from the other end, so the write never completes.
+	err := peer.Send([]byte("hello"))
+	assert.ErrorIs(t, err, os.ErrDeadlineExceeded)
+	select {
+	case <-peer.closed:
+	default:
+		t.Fatal("peer not closed after a failed send")
+	}
+}
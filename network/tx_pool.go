@@ -22,27 +22,82 @@ func NewTxPool(maxLength int) *TxPool {
 }
 
 func (p *TxPool) Add(tx *core.Transaction) {
-	if p.all.Count() == p.maxLength {
-		oldest := p.all.First()
-		p.all.Remove(oldest.Hash(core.TxHasher{}))
+	if !p.all.Contains(tx.Hash(core.TxHasher{})) {
+		p.Restore(tx)
 	}
+}
 
-	if !p.all.Contains(tx.Hash(core.TxHasher{})) {
+// Restore adds tx to the pending transactions even if the pool has seen it
+// before, e.g. when the block that included it is dropped by a reorg.
+func (p *TxPool) Restore(tx *core.Transaction) {
+	hash := tx.Hash(core.TxHasher{})
+
+	if !p.all.Contains(hash) {
+		if p.all.Count() == p.maxLength {
+			oldest := p.all.First()
+			p.all.Remove(oldest.Hash(core.TxHasher{}))
+		}
 		p.all.Add(tx)
-		p.pending.Add(tx)
 	}
+	p.pending.Add(tx)
 }
 
 func (p *TxPool) ClearPending() {
 	p.pending.Clear()
 }
 
+// Remove forgets the given transactions, so they are accepted again if they
+// are sent once more.
+func (p *TxPool) Remove(txx []*core.Transaction) {
+	for _, tx := range txx {
+		hash := tx.Hash(core.TxHasher{})
+		p.all.Remove(hash)
+		p.pending.Remove(hash)
+	}
+}
+
+// RemovePending removes the given transactions, e.g. those included in a
+// block, from the pending transactions. Others added in the meantime stay.
+func (p *TxPool) RemovePending(txx []*core.Transaction) {
+	for _, tx := range txx {
+		p.pending.Remove(tx.Hash(core.TxHasher{}))
+	}
+}
+
 func (p *TxPool) Contains(hash types.Hash) bool {
 	return p.all.Contains(hash)
 }
 
 func (p *TxPool) Pending() []*core.Transaction {
-	return p.all.txx.Data
+	p.pending.lock.RLock()
+	defer p.pending.lock.RUnlock()
+
+	txx := make([]*core.Transaction, len(p.pending.txx.Data))
+	copy(txx, p.pending.txx.Data)
+	return txx
+}
+
+// PendingNonce returns the nonce the next pending transaction of addr must
+// carry, or false if the pool holds no pending transactions from addr.
+func (p *TxPool) PendingNonce(addr types.Address) (uint64, bool) {
+	p.pending.lock.RLock()
+	defer p.pending.lock.RUnlock()
+
+	var (
+		next  uint64
+		found bool
+	)
+	for _, tx := range p.pending.txx.Data {
+		if tx.From.Address() != addr {
+			continue
+		}
+		if !found || tx.Nonce+1 > next {
+			next = tx.Nonce + 1
+			found = true
+		}
+	}
+
+	return next, found
 }
 
 func (p *TxPool) PendingCount() int {
@@ -106,7 +161,7 @@ func (t *TxSortedMap) Get(h types.Hash) *core.Transaction {
 }
 
 func (t *TxSortedMap) First() *core.Transaction {
-	t.lock.Lock()
+	t.lock.RLock()
 	defer t.lock.RUnlock()
 
 	first := t.txx.Get(0)
@@ -117,6 +172,10 @@ func (t *TxSortedMap) Remove(h types.Hash) {
 	t.lock.Lock()
 	defer t.lock.Unlock()
 
-	t.txx.Remove(t.lookup[h])
+	tx, ok := t.lookup[h]
+	if !ok {
+		return
+	}
+	t.txx.Remove(tx)
 	delete(t.lookup, h)
 }
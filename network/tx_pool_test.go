@@ -5,6 +5,7 @@ import (
 	"testing"
 
 	"github.com/3ssalunke/go-blockchain/core"
+	"github.com/3ssalunke/go-blockchain/crypto"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -36,3 +37,53 @@ func TestSortTransactions(t *testing.T) {
 
 	assert.Equal(t, p.pending.Count(), 1000)
 }
+
+func TestPoolPendingNonce(t *testing.T) {
+	p := NewTxPool(100)
+	privKey := crypto.GeneratePrivateKey()
+	addr := privKey.PublicKey().Address()
+
+	_, ok := p.PendingNonce(addr)
+	assert.False(t, ok)
+
+	for i := 0; i < 3; i++ {
+		tx := core.NewTransaction([]byte("foo"))
+		tx.Nonce = uint64(i)
+		assert.Nil(t, tx.Sign(privKey))
+		p.Add(tx)
+	}
+
+	nonce, ok := p.PendingNonce(addr)
+	assert.True(t, ok)
+	assert.Equal(t, uint64(3), nonce)
+	assert.Equal(t, 3, len(p.Pending()))
+
+	p.ClearPending()
+	_, ok = p.PendingNonce(addr)
+	assert.False(t, ok)
+}
+
+func TestPoolRestore(t *testing.T) {
+	p := NewTxPool(100)
+	tx := core.NewTransaction([]byte("foo"))
+	p.Add(tx)
+	p.ClearPending()
+
+	// A transaction the pool has seen is only added back by Restore.
+	p.Add(tx)
+	assert.Equal(t, 0, p.PendingCount())
+	p.Restore(tx)
+	assert.Equal(t, 1, p.PendingCount())
+	assert.Equal(t, 1, p.all.Count())
+}
+
+func TestPoolRemovePending(t *testing.T) {
+	p := NewTxPool(100)
+	included := core.NewTransaction([]byte("foo"))
+	later := core.NewTransaction([]byte("bar"))
+	p.Add(included)
+	p.Add(later)
+
+	p.RemovePending([]*core.Transaction{included, included})
+	assert.Equal(t, []*core.Transaction{later}, p.Pending())
+}
@@ -0,0 +1,177 @@
+package util
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// MaxBytesLen bounds every length-prefixed field read by BinaryReader so a
+// malicious length prefix cannot make the reader allocate arbitrary memory.
+const MaxBytesLen = 32 << 20
+
+// BinaryWriter builds the canonical binary encoding used for consensus and
+// network data: fixed-width little-endian integers and byte strings prefixed
+// with their uint32 length.
+type BinaryWriter struct {
+	buf []byte
+}
+
+func NewBinaryWriter() *BinaryWriter {
+	return &BinaryWriter{}
+}
+
+func (w *BinaryWriter) WriteUint8(v uint8) {
+	w.buf = append(w.buf, v)
+}
+
+func (w *BinaryWriter) WriteBool(v bool) {
+	if v {
+		w.WriteUint8(1)
+	} else {
+		w.WriteUint8(0)
+	}
+}
+
+func (w *BinaryWriter) WriteUint32(v uint32) {
+	w.buf = binary.LittleEndian.AppendUint32(w.buf, v)
+}
+
+func (w *BinaryWriter) WriteUint64(v uint64) {
+	w.buf = binary.LittleEndian.AppendUint64(w.buf, v)
+}
+
+func (w *BinaryWriter) WriteInt64(v int64) {
+	w.WriteUint64(uint64(v))
+}
+
+// WriteFixed writes b as is. The reader must know its length.
+func (w *BinaryWriter) WriteFixed(b []byte) {
+	w.buf = append(w.buf, b...)
+}
+
+// WriteBytes writes b prefixed with its length.
+func (w *BinaryWriter) WriteBytes(b []byte) {
+	w.WriteUint32(uint32(len(b)))
+	w.WriteFixed(b)
+}
+
+func (w *BinaryWriter) WriteString(s string) {
+	w.WriteBytes([]byte(s))
+}
+
+func (w *BinaryWriter) Bytes() []byte {
+	return w.buf
+}
+
+// BinaryReader decodes what BinaryWriter wrote. The first error is sticky:
+// every later read returns a zero value and Err reports it.
+type BinaryReader struct {
+	r   io.Reader
+	err error
+}
+
+func NewBinaryReader(r io.Reader) *BinaryReader {
+	return &BinaryReader{
+		r: r,
+	}
+}
+
+func (r *BinaryReader) Err() error {
+	return r.err
+}
+
+func (r *BinaryReader) ReadUint8() uint8 {
+	b := r.ReadFixed(1)
+	if b == nil {
+		return 0
+	}
+	return b[0]
+}
+
+func (r *BinaryReader) ReadBool() bool {
+	switch v := r.ReadUint8(); v {
+	case 0:
+		return false
+	case 1:
+		return true
+	default:
+		r.Fail(fmt.Errorf("invalid bool value %d", v))
+		return false
+	}
+}
+
+func (r *BinaryReader) ReadUint32() uint32 {
+	b := r.ReadFixed(4)
+	if b == nil {
+		return 0
+	}
+	return binary.LittleEndian.Uint32(b)
+}
+
+func (r *BinaryReader) ReadUint64() uint64 {
+	b := r.ReadFixed(8)
+	if b == nil {
+		return 0
+	}
+	return binary.LittleEndian.Uint64(b)
+}
+
+func (r *BinaryReader) ReadInt64() int64 {
+	return int64(r.ReadUint64())
+}
+
+// ReadFixed reads exactly n bytes.
+func (r *BinaryReader) ReadFixed(n int) []byte {
+	if r.err != nil {
+		return nil
+	}
+
+	b := make([]byte, n)
+	if _, err := io.ReadFull(r.r, b); err != nil {
+		r.Fail(err)
+		return nil
+	}
+	return b
+}
+
+// ReadBytes reads a length-prefixed byte string. An empty string is
+// returned as nil.
+func (r *BinaryReader) ReadBytes() []byte {
+	n := r.ReadUint32()
+	if r.err != nil || n == 0 {
+		return nil
+	}
+	if n > MaxBytesLen {
+		r.Fail(fmt.Errorf("length %d exceeds maximum of %d", n, MaxBytesLen))
+		return nil
+	}
+	return r.ReadFixed(int(n))
+}
+
+func (r *BinaryReader) ReadString() string {
+	return string(r.ReadBytes())
+}
+
+// ReadEOF checks that everything has been read, failing if the input goes
+// on.
+func (r *BinaryReader) ReadEOF() {
+	if r.err != nil {
+		return
+	}
+
+	n, err := io.ReadFull(r.r, make([]byte, 1))
+	switch {
+	case n > 0:
+		r.Fail(fmt.Errorf("unexpected trailing data"))
+	case err != io.EOF:
+		r.Fail(err)
+	}
+}
+
+// Fail records err unless an earlier error is already recorded.
+func (r *BinaryReader) Fail(err error) {
+	if r.err == nil {
+		r.err = err
+	}
+}